@@ -0,0 +1,78 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/golang/snappy"
+)
+
+// compressionCodecFor maps a ProducerConfig.CompressionType value to the codec used for produced message sets.
+func compressionCodecFor(compressionType string) (CompressionCodec, bool) {
+	switch compressionType {
+	case "", "none":
+		return CompressionNone, true
+	case "gzip":
+		return CompressionGZIP, true
+	case "snappy":
+		return CompressionSnappy, true
+	}
+	return CompressionNone, false
+}
+
+// compressMessages encodes the given messages as a message set and wraps it, compressed with a given codec, into a single message.
+func compressMessages(codec CompressionCodec, messages []*Message) (*Message, error) {
+	set := make([]*MessageAndOffset, len(messages))
+	sizing := NewSizingEncoder()
+	for i, message := range messages {
+		set[i] = &MessageAndOffset{Offset: int64(i), Message: message}
+		set[i].Write(sizing)
+	}
+
+	encoder := NewBinaryEncoder(make([]byte, sizing.Size()))
+	for _, messageAndOffset := range set {
+		messageAndOffset.Write(encoder)
+	}
+
+	var compressed []byte
+	switch codec {
+	case CompressionGZIP:
+		var err error
+		if compressed, err = gzipCompress(encoder.buffer); err != nil {
+			return nil, err
+		}
+	case CompressionSnappy:
+		compressed = snappy.Encode(nil, encoder.buffer)
+	default:
+		return nil, ErrUnsupportedCompressionCodec
+	}
+
+	return &Message{Attributes: int8(codec) & compressionCodecMask, Value: compressed}, nil
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
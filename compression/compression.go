@@ -0,0 +1,165 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package compression helps to choose a siesta.ProducerConfig.CompressionType by benchmarking the supported algorithms
+// on sample payloads.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// CompressionPriority tells CompressionReport.Best what to optimize for.
+type CompressionPriority string
+
+const (
+	// CompressionPriorityRatio picks the algorithm producing the smallest output.
+	CompressionPriorityRatio CompressionPriority = "ratio"
+
+	// CompressionPrioritySpeed picks the algorithm with the lowest total compression and decompression time.
+	CompressionPrioritySpeed CompressionPriority = "speed"
+
+	// CompressionPriorityBalanced weighs ratio and speed equally.
+	CompressionPriorityBalanced CompressionPriority = "balanced"
+)
+
+// CompressionResult holds the benchmark outcome for a single compression algorithm.
+type CompressionResult struct {
+	// Algorithm is the name of the algorithm as accepted by siesta.ProducerConfig.CompressionType.
+	Algorithm string
+
+	// Ratio is the total compressed size divided by the total original size, lower is better.
+	Ratio float64
+
+	CompressionTime   time.Duration
+	DecompressionTime time.Duration
+}
+
+// CompressionReport contains a CompressionResult for every benchmarked algorithm.
+type CompressionReport struct {
+	Results []*CompressionResult
+}
+
+type algorithm struct {
+	name       string
+	compress   func([]byte) ([]byte, error)
+	decompress func([]byte) ([]byte, error)
+}
+
+// only algorithms the producer supports are benchmarked, LZ4 and Zstd are not supported yet.
+var algorithms = []*algorithm{
+	{"none", func(data []byte) ([]byte, error) { return append([]byte(nil), data...), nil }, func(data []byte) ([]byte, error) { return append([]byte(nil), data...), nil }},
+	{"gzip", gzipCompress, gzipDecompress},
+	{"snappy", func(data []byte) ([]byte, error) { return snappy.Encode(nil, data), nil }, func(data []byte) ([]byte, error) { return snappy.Decode(nil, data) }},
+}
+
+// BenchmarkAlgorithms compresses and decompresses every given sample with each supported algorithm
+// (none, gzip and snappy) and reports the achieved ratio and the time spent.
+func BenchmarkAlgorithms(samples [][]byte) (*CompressionReport, error) {
+	report := new(CompressionReport)
+	for _, algorithm := range algorithms {
+		result := &CompressionResult{Algorithm: algorithm.name}
+		var originalSize, compressedSize int
+		for _, sample := range samples {
+			start := time.Now()
+			compressed, err := algorithm.compress(sample)
+			if err != nil {
+				return nil, err
+			}
+			result.CompressionTime += time.Since(start)
+
+			start = time.Now()
+			if _, err := algorithm.decompress(compressed); err != nil {
+				return nil, err
+			}
+			result.DecompressionTime += time.Since(start)
+
+			originalSize += len(sample)
+			compressedSize += len(compressed)
+		}
+
+		if originalSize > 0 {
+			result.Ratio = float64(compressedSize) / float64(originalSize)
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+// Best returns the name of the algorithm that matches a given priority best.
+// Unknown priorities are treated as CompressionPriorityBalanced. Returns an empty string if the report is empty.
+func (cr *CompressionReport) Best(priority CompressionPriority) string {
+	var maxTime time.Duration
+	for _, result := range cr.Results {
+		if total := result.CompressionTime + result.DecompressionTime; total > maxTime {
+			maxTime = total
+		}
+	}
+
+	score := func(result *CompressionResult) float64 {
+		total := result.CompressionTime + result.DecompressionTime
+		switch priority {
+		case CompressionPriorityRatio:
+			return result.Ratio
+		case CompressionPrioritySpeed:
+			return float64(total)
+		default:
+			if maxTime == 0 {
+				return result.Ratio
+			}
+			return result.Ratio + float64(total)/float64(maxTime)
+		}
+	}
+
+	var best *CompressionResult
+	for _, result := range cr.Results {
+		if best == nil || score(result) < score(best) {
+			best = result
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+	return best.Algorithm
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func gzipDecompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,74 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package compression
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkAlgorithms(t *testing.T) {
+	samples := [][]byte{bytes.Repeat([]byte("siesta"), 1000), []byte{}, []byte("a")}
+	report, err := BenchmarkAlgorithms(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(report.Results))
+	}
+	results := make(map[string]*CompressionResult)
+	for _, result := range report.Results {
+		results[result.Algorithm] = result
+	}
+	if results["none"].Ratio != 1.0 {
+		t.Errorf("Expected ratio 1 for none, got %f", results["none"].Ratio)
+	}
+	if results["gzip"].Ratio >= 0.1 {
+		t.Errorf("Expected ratio below 0.1 for gzip, got %f", results["gzip"].Ratio)
+	}
+	if results["snappy"] == nil {
+		t.Error("Expected a result for snappy")
+	}
+	if best := report.Best(CompressionPriorityRatio); best != "gzip" {
+		t.Errorf("Expected gzip to have the best ratio, got %s", best)
+	}
+}
+
+func TestCompressionReportBest(t *testing.T) {
+	report := &CompressionReport{Results: []*CompressionResult{
+		{Algorithm: "none", Ratio: 1.0, CompressionTime: time.Millisecond},
+		{Algorithm: "gzip", Ratio: 0.2, CompressionTime: 100 * time.Millisecond},
+		{Algorithm: "snappy", Ratio: 0.4, CompressionTime: 10 * time.Millisecond},
+	}}
+
+	for priority, expected := range map[CompressionPriority]string{
+		CompressionPriorityRatio:    "gzip",
+		CompressionPrioritySpeed:    "none",
+		CompressionPriorityBalanced: "snappy",
+		"unknown":                   "snappy",
+	} {
+		if best := report.Best(priority); best != expected {
+			t.Errorf("Expected %s for priority %s, got %s", expected, priority, best)
+		}
+	}
+	if best := new(CompressionReport).Best(CompressionPriorityRatio); best != "" {
+		t.Errorf("Expected no algorithm for an empty report, got %s", best)
+	}
+}
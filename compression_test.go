@@ -0,0 +1,92 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressMessages(t *testing.T) {
+	messages := []*Message{{Key: []byte("key"), Value: []byte("value1")}, {Value: []byte("value2")}}
+	for _, codec := range []CompressionCodec{CompressionGZIP, CompressionSnappy} {
+		wrapper, err := compressMessages(codec, messages)
+		checkErr(t, err)
+
+		sizing := NewSizingEncoder()
+		wrapper.Write(sizing)
+		encoder := NewBinaryEncoder(make([]byte, sizing.Size()))
+		wrapper.Write(encoder)
+
+		decoded := new(Message)
+		decodingErr := decoded.Read(NewBinaryDecoder(encoder.buffer))
+		if decodingErr != nil {
+			t.Fatal(decodingErr.Error())
+		}
+		assert(t, CompressionCodec(decoded.Attributes&compressionCodecMask), codec)
+		assertFatal(t, len(decoded.Nested), 2)
+		for i, nested := range decoded.Nested {
+			assert(t, nested.Offset, int64(i))
+			assert(t, string(nested.Message.Key), string(messages[i].Key))
+			assert(t, string(nested.Message.Value), string(messages[i].Value))
+		}
+	}
+
+	_, err := compressMessages(CompressionLZ4, messages)
+	assert(t, err, ErrUnsupportedCompressionCodec)
+}
+
+func TestCompressionCodecFor(t *testing.T) {
+	for compressionType, expected := range map[string]CompressionCodec{"": CompressionNone, "none": CompressionNone, "gzip": CompressionGZIP, "snappy": CompressionSnappy} {
+		codec, supported := compressionCodecFor(compressionType)
+		assert(t, supported, true)
+		assert(t, codec, expected)
+	}
+
+	for _, compressionType := range []string{"lz4", "zstd", "GZIP"} {
+		_, supported := compressionCodecFor(compressionType)
+		assert(t, supported, false)
+	}
+}
+
+func benchmarkCompressMessages(b *testing.B, codec CompressionCodec) {
+	payload := bytes.Repeat([]byte("siesta benchmark payload "), 41)[:1024]
+	messages := make([]*Message, 10000)
+	for i := range messages {
+		messages[i] = &Message{Value: payload}
+	}
+
+	b.SetBytes(int64(len(payload) * len(messages)))
+	b.ResetTimer()
+	var compressedSize int
+	for i := 0; i < b.N; i++ {
+		wrapper, err := compressMessages(codec, messages)
+		if err != nil {
+			b.Fatal(err)
+		}
+		compressedSize = len(wrapper.Value)
+	}
+	b.StopTimer()
+	b.Logf("compression ratio %.4f", float64(compressedSize)/float64(len(payload)*len(messages)))
+}
+
+func BenchmarkCompressMessagesGzip(b *testing.B) {
+	benchmarkCompressMessages(b, CompressionGZIP)
+}
+
+func BenchmarkCompressMessagesSnappy(b *testing.B) {
+	benchmarkCompressMessages(b, CompressionSnappy)
+}
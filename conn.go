@@ -16,30 +16,59 @@ limitations under the License. */
 package siesta
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
 	"time"
 )
 
+// maxConnectLatencySamples is the number of most recent connect latencies kept per connection pool.
+const maxConnectLatencySamples = 1000
+
+// defaultTLSHandshakeTimeout bounds the TLS handshake of new connections, so that a silent broker can't block them.
+const defaultTLSHandshakeTimeout = 10 * time.Second
+
+// ConnectionStats describes the connections to a single broker.
+type ConnectionStats struct {
+	// Attempts is the number of connections the pool tried to establish.
+	Attempts int64
+
+	// Failures is the number of connection attempts that failed.
+	Failures int64
+
+	// Active is the number of currently open connections, both borrowed and idle.
+	Active int32
+
+	// ConnectLatencies holds the time it took to establish the most recent successful connections.
+	ConnectLatencies []time.Duration
+}
+
 type connectionPool struct {
 	connectStr       string
 	size             int
 	conns            int
+	attempts         int64
+	failures         int64
+	latencies        []time.Duration
 	keepAlive        bool
 	keepAlivePeriod  time.Duration
-	connections      []*net.TCPConn
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
+	connections      []net.Conn
+	returnedAt       []time.Time
 	lock             sync.Mutex
 	connReleasedCond *sync.Cond
 }
 
 func newConnectionPool(connectStr string, size int, keepAlive bool, keepAlivePeriod time.Duration) *connectionPool {
 	pool := &connectionPool{
-		connectStr:      connectStr,
-		size:            size,
-		conns:           0,
-		keepAlive:       keepAlive,
-		keepAlivePeriod: keepAlivePeriod,
-		connections:     make([]*net.TCPConn, 0),
+		connectStr:       connectStr,
+		size:             size,
+		conns:            0,
+		keepAlive:        keepAlive,
+		keepAlivePeriod:  keepAlivePeriod,
+		handshakeTimeout: defaultTLSHandshakeTimeout,
+		connections:      make([]net.Conn, 0),
 	}
 
 	pool.connReleasedCond = sync.NewCond(&pool.lock)
@@ -47,7 +76,7 @@ func newConnectionPool(connectStr string, size int, keepAlive bool, keepAlivePer
 	return pool
 }
 
-func (cp *connectionPool) Borrow() (conn *net.TCPConn, err error) {
+func (cp *connectionPool) Borrow() (conn net.Conn, err error) {
 	inLock(&cp.lock, func() {
 		for cp.conns >= cp.size && len(cp.connections) == 0 {
 			cp.connReleasedCond.Wait()
@@ -56,27 +85,92 @@ func (cp *connectionPool) Borrow() (conn *net.TCPConn, err error) {
 		if len(cp.connections) > 0 {
 			conn = cp.connections[0]
 			cp.connections = cp.connections[1:]
+			cp.returnedAt = cp.returnedAt[1:]
 		} else {
+			cp.attempts++
+			start := time.Now()
 			conn, err = cp.connect()
 			if err != nil {
+				cp.failures++
 				return
 			}
+			cp.addLatency(time.Since(start))
 			cp.conns++
 		}
 	})
 	return conn, err
 }
 
-func (cp *connectionPool) Return(conn *net.TCPConn) {
+func (cp *connectionPool) Return(conn net.Conn) {
 	inLock(&cp.lock, func() {
 		if len(cp.connections) < cp.conns {
 			cp.connections = append(cp.connections, conn)
+			cp.returnedAt = append(cp.returnedAt, time.Now())
+			cp.connReleasedCond.Broadcast()
+		}
+	})
+}
+
+// Discard closes a given broken connection and frees its slot in the pool so that a new connection is established on next Borrow.
+func (cp *connectionPool) Discard(conn net.Conn) {
+	inLock(&cp.lock, func() {
+		conn.Close()
+		cp.conns--
+		cp.connReleasedCond.Broadcast()
+	})
+}
+
+// CloseIdle closes connections that were returned to the pool more than a given timeout ago and frees their slots,
+// so that a new connection is established on next Borrow. Returns the number of closed connections.
+func (cp *connectionPool) CloseIdle(timeout time.Duration) (closed int) {
+	inLock(&cp.lock, func() {
+		// connections are borrowed from the front and returned to the back, so the idle ones come first
+		for len(cp.connections) > 0 && time.Since(cp.returnedAt[0]) >= timeout {
+			cp.connections[0].Close()
+			cp.connections = cp.connections[1:]
+			cp.returnedAt = cp.returnedAt[1:]
+			cp.conns--
+			closed++
+		}
+		if closed > 0 {
 			cp.connReleasedCond.Broadcast()
 		}
 	})
+	return closed
 }
 
-func (cp *connectionPool) connect() (*net.TCPConn, error) {
+// Stats returns a snapshot of the connection statistics of this pool.
+func (cp *connectionPool) Stats() (stats ConnectionStats) {
+	inLock(&cp.lock, func() {
+		stats = ConnectionStats{
+			Attempts:         cp.attempts,
+			Failures:         cp.failures,
+			Active:           int32(cp.conns),
+			ConnectLatencies: append([]time.Duration(nil), cp.latencies...),
+		}
+	})
+	return stats
+}
+
+// ResetStats zeroes the connection attempts and failures and drops all connect latency samples.
+// The number of active connections is a gauge and is left intact.
+func (cp *connectionPool) ResetStats() {
+	inLock(&cp.lock, func() {
+		cp.attempts = 0
+		cp.failures = 0
+		cp.latencies = nil
+	})
+}
+
+// addLatency must be called with the pool lock held.
+func (cp *connectionPool) addLatency(latency time.Duration) {
+	if len(cp.latencies) >= maxConnectLatencySamples {
+		cp.latencies = cp.latencies[1:]
+	}
+	cp.latencies = append(cp.latencies, latency)
+}
+
+func (cp *connectionPool) connect() (net.Conn, error) {
 	addr, err := net.ResolveTCPAddr("tcp", cp.connectStr)
 	if err != nil {
 		return nil, err
@@ -91,5 +185,16 @@ func (cp *connectionPool) connect() (*net.TCPConn, error) {
 		conn.SetKeepAlivePeriod(cp.keepAlivePeriod)
 	}
 
+	if cp.tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsClientConfig(cp.tlsConfig, cp.connectStr))
+		tlsConn.SetDeadline(time.Now().Add(cp.handshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+
 	return conn, nil
 }
@@ -109,6 +109,49 @@ func TestConnectionPoolReturnMoreThanAllowed(t *testing.T) {
 	listener.Close()
 }
 
+func TestConnectionPoolDiscardedIsReestablished(t *testing.T) {
+	listener := startTCPListener(t)
+	accepted := make(chan bool, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// simulate a connection dropped after inactivity
+			conn.Close()
+			accepted <- true
+		}
+	}()
+
+	pool := newConnectionPool(listener.Addr().String(), 1, true, 1*time.Second)
+	conn, err := pool.Borrow()
+	assertFatal(t, err, nil)
+	<-accepted
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the dropped connection to fail")
+	}
+	pool.Discard(conn)
+	assert(t, pool.conns, 0)
+
+	borrowed := make(chan bool)
+	go func() {
+		_, err := pool.Borrow()
+		assert(t, err, nil)
+		borrowed <- true
+	}()
+
+	select {
+	case <-borrowed:
+	case <-time.After(time.Second):
+		t.Error("Unable to borrow a new connection after the broken one was discarded")
+	}
+	assert(t, pool.conns, 1)
+	listener.Close()
+}
+
 func TestConnectionPoolBadAddresses(t *testing.T) {
 	pool := newConnectionPool("localhost", 1, true, 1*time.Second)
 	conn, err := pool.Borrow()
@@ -125,3 +168,50 @@ func TestConnectionPoolBadAddresses(t *testing.T) {
 		conn.Close()
 	}
 }
+
+func TestConnectionPoolStats(t *testing.T) {
+	listener := startTCPListener(t)
+	pool := newConnectionPool(listener.Addr().String(), 2, true, 1*time.Second)
+	conn, err := pool.Borrow()
+	assertFatal(t, err, nil)
+	pool.Return(conn)
+	_, err = pool.Borrow()
+	assertFatal(t, err, nil)
+
+	stats := pool.Stats()
+	assert(t, stats.Attempts, int64(1))
+	assert(t, stats.Failures, int64(0))
+	assert(t, stats.Active, int32(1))
+	assert(t, len(stats.ConnectLatencies), 1)
+	listener.Close()
+
+	pool = newConnectionPool("localhost:0", 1, true, 1*time.Second)
+	pool.Borrow()
+	stats = pool.Stats()
+	assert(t, stats.Attempts, int64(1))
+	assert(t, stats.Failures, int64(1))
+	assert(t, stats.Active, int32(0))
+}
+
+func TestConnectionPoolCloseIdle(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	pool := newConnectionPool(listener.Addr().String(), 2, true, 1*time.Second)
+	first, err := pool.Borrow()
+	assertFatal(t, err, nil)
+	second, err := pool.Borrow()
+	assertFatal(t, err, nil)
+
+	pool.Return(first)
+	time.Sleep(50 * time.Millisecond)
+	pool.Return(second)
+
+	assert(t, pool.CloseIdle(time.Minute), 0)
+	assert(t, pool.CloseIdle(25*time.Millisecond), 1)
+	assert(t, pool.Stats().Active, int32(1))
+
+	conn, err := pool.Borrow()
+	assertFatal(t, err, nil)
+	assert(t, conn, second)
+	assert(t, pool.CloseIdle(0), 0)
+}
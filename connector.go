@@ -20,6 +20,7 @@
 package siesta
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -43,20 +44,40 @@ type Connector interface {
 	// More on offset time here - https://cwiki.apache.org/confluence/display/KAFKA/A+Guide+To+The+Kafka+Protocol#AGuideToTheKafkaProtocol-OffsetRequest
 	GetAvailableOffset(topic string, partition int32, offsetTime int64) (int64, error)
 
+	// ListOffsets issues offset requests for given topics and partitions to their leaders and returns the merged responses.
+	ListOffsets(requestInfo map[string][]*PartitionOffsetRequestInfo) (*OffsetResponse, error)
+
 	// Fetch issues a single fetch request to a broker responsible for a given topic and partition and returns a FetchResponse that contains messages starting from a given offset.
 	Fetch(topic string, partition int32, offset int64) (*FetchResponse, error)
 
 	// GetOffset gets the offset for a given group, topic and partition from Kafka. A part of new offset management API.
 	GetOffset(group string, topic string, partition int32) (int64, error)
 
+	// OffsetFetch gets the committed offsets for a given group and given topics and partitions from the group's offset coordinator.
+	OffsetFetch(group string, topics map[string][]int32) (*OffsetFetchResponse, error)
+
 	// CommitOffset commits the offset for a given group, topic and partition to Kafka. A part of new offset management API.
 	CommitOffset(group string, topic string, partition int32, offset int64) error
 
 	GetLeader(topic string, partition int32) (BrokerLink, error)
 
+	// Ping checks whether at least one broker answers a small request within a given timeout.
+	// Returns ErrBrokerNotAvailable if no response arrives in time.
+	Ping(timeout time.Duration) error
+
 	// Tells the Connector to close all existing connections and stop.
 	// This method is NOT blocking but returns a channel which will get a single value once the closing is finished.
 	Close() <-chan bool
+
+	// WithRequestInterceptor adds a function that may inspect or replace the encoded bytes of every request this Connector
+	// or a KafkaProducer using it sends, following the length field (i.e. the request header and body).
+	// Functions run in the order they were added. Returns this Connector so that calls can be chained.
+	WithRequestInterceptor(fn func(apiKey int16, body []byte) []byte) Connector
+
+	// WithResponseInterceptor adds a function that may inspect or replace the bytes of every response this Connector
+	// or a KafkaProducer using it receives, following the correlation ID, before they are decoded.
+	// Functions run in the order they were added. Returns this Connector so that calls can be chained.
+	WithResponseInterceptor(fn func(apiKey int16, body []byte) []byte) Connector
 }
 
 // ConnectorConfig is used to pass multiple configuration values for a Connector
@@ -114,6 +135,13 @@ type ConnectorConfig struct {
 
 	// ClientID that will be used by a connector to identify client requests by broker.
 	ClientID string
+
+	// TLSConfig enables TLS for all broker connections if set, see TLSConfigFromFiles.
+	// The server name is taken from the broker address unless set explicitly.
+	TLSConfig *tls.Config
+
+	// RequestTimeouts overrides ReadTimeout for requests with a given Kafka API key, e.g. a longer one for offset commits.
+	RequestTimeouts map[int16]time.Duration
 }
 
 // NewConnectorConfig returns a new ConnectorConfig with sane defaults.
@@ -216,6 +244,8 @@ type DefaultConnector struct {
 	bootstrapLinks []*brokerLink
 	lock           sync.Mutex
 
+	interceptors interceptors
+
 	//offset coordination part
 	offsetCoordinators map[string]int32
 }
@@ -267,6 +297,60 @@ func (dc *DefaultConnector) GetAvailableOffset(topic string, partition int32, of
 	return -1, err
 }
 
+// ListOffsets issues offset requests for given topics and partitions to their leaders, one request per leader, and
+// returns the merged responses. Partition errors are returned within the response, leaders are forgotten if a request fails.
+func (dc *DefaultConnector) ListOffsets(requestInfo map[string][]*PartitionOffsetRequestInfo) (*OffsetResponse, error) {
+	requests := make(map[*brokerLink]*OffsetRequest)
+	for topic, infos := range requestInfo {
+		for _, info := range infos {
+			link := dc.getLeader(topic, info.Partition)
+			if link == nil {
+				leader, err := dc.tryGetLeader(topic, info.Partition, dc.config.MetadataRetries)
+				if err != nil {
+					return nil, err
+				}
+				link = leader
+			}
+
+			if requests[link] == nil {
+				requests[link] = new(OffsetRequest)
+			}
+			requests[link].AddPartitionOffsetRequestInfo(topic, info.Partition, info.Time, info.MaxNumOffsets)
+		}
+	}
+
+	merged := &OffsetResponse{PartitionErrorAndOffsets: make(map[string]map[int32]*PartitionOffsetsResponse)}
+	for link, request := range requests {
+		bytes, err := dc.syncSendAndReceive(link, request)
+		if err != nil {
+			for topic, infos := range request.RequestInfo {
+				for _, info := range infos {
+					dc.removeLeader(topic, info.Partition)
+				}
+			}
+			return nil, err
+		}
+
+		response := new(OffsetResponse)
+		decodingErr := dc.decode(bytes, response)
+		if decodingErr != nil {
+			Errorf(dc, "Could not decode an OffsetResponse. Reason: %s", decodingErr.Reason())
+			return nil, decodingErr.Error()
+		}
+
+		for topic, offsets := range response.PartitionErrorAndOffsets {
+			if merged.PartitionErrorAndOffsets[topic] == nil {
+				merged.PartitionErrorAndOffsets[topic] = make(map[int32]*PartitionOffsetsResponse)
+			}
+			for partition, offset := range offsets {
+				merged.PartitionErrorAndOffsets[topic][partition] = offset
+			}
+		}
+	}
+
+	return merged, nil
+}
+
 // Fetch issues a single fetch request to a broker responsible for a given topic and partition and returns a FetchResponse that contains messages starting from a given offset.
 func (dc *DefaultConnector) Fetch(topic string, partition int32, offset int64) (*FetchResponse, error) {
 	link := dc.getLeader(topic, partition)
@@ -334,6 +418,30 @@ func (dc *DefaultConnector) GetOffset(group string, topic string, partition int3
 	}
 }
 
+// OffsetFetch gets the committed offsets for a given group and given topics and partitions from the group's offset coordinator.
+// Partition errors, e.g. ErrUnknownTopicOrPartition for partitions without a committed offset, are returned within the response.
+func (dc *DefaultConnector) OffsetFetch(group string, topics map[string][]int32) (*OffsetFetchResponse, error) {
+	coordinator, err := dc.getOffsetCoordinator(group)
+	if err != nil {
+		return nil, err
+	}
+
+	request := NewOffsetFetchRequest(group)
+	request.RequestInfo = topics
+	bytes, err := dc.syncSendAndReceive(coordinator, request)
+	if err != nil {
+		return nil, err
+	}
+	response := new(OffsetFetchResponse)
+	decodingErr := dc.decode(bytes, response)
+	if decodingErr != nil {
+		Errorf(dc, "Could not decode an OffsetFetchResponse. Reason: %s", decodingErr.Reason())
+		return nil, decodingErr.Error()
+	}
+
+	return response, nil
+}
+
 // CommitOffset commits the offset for a given group, topic and partition to Kafka. A part of new offset management API.
 func (dc *DefaultConnector) CommitOffset(group string, topic string, partition int32, offset int64) error {
 	for i := 0; i <= dc.config.CommitOffsetRetries; i++ {
@@ -361,6 +469,31 @@ func (dc *DefaultConnector) GetLeader(topic string, partition int32) (BrokerLink
 	return link, nil
 }
 
+// Ping checks whether at least one broker answers a consumer metadata request for the client id within a given timeout.
+// This is used instead of a metadata request as one without topics returns metadata for the whole cluster.
+// Any decodable response counts, even if it carries an error code. Known brokers are pinged if there are any, bootstrap brokers otherwise.
+// Returns ErrBrokerNotAvailable if no response arrives in time.
+func (dc *DefaultConnector) Ping(timeout time.Duration) error {
+	links := dc.links
+	if len(links) == 0 {
+		dc.initBootstrapLinks()
+		links = dc.bootstrapLinks
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := dc.sendToAllLinks(links, NewConsumerMetadataRequest(dc.config.ClientID), dc.pingValidator)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return ErrBrokerNotAvailable
+	}
+}
+
 // Close tells the Connector to close all existing connections and stop.
 // This method is NOT blocking but returns a channel which will get a single value once the closing is finished.
 func (dc *DefaultConnector) Close() <-chan bool {
@@ -384,7 +517,7 @@ func (dc *DefaultConnector) closeBrokerLinks() {
 	}
 }
 
-func (dc *DefaultConnector) refreshMetadata(topics []string) {
+func (dc *DefaultConnector) initBootstrapLinks() {
 	if len(dc.bootstrapLinks) == 0 {
 		for i := 0; i < len(dc.config.BrokerList); i++ {
 			broker := dc.config.BrokerList[i]
@@ -398,12 +531,13 @@ func (dc *DefaultConnector) refreshMetadata(topics []string) {
 				panic(fmt.Sprintf("incorrect port in broker connection string: %s", broker))
 			}
 
-			dc.bootstrapLinks = append(dc.bootstrapLinks, newBrokerLink(&Broker{ID: -1, Host: hostPort[0], Port: int32(port)},
-				dc.config.KeepAlive,
-				dc.config.KeepAliveTimeout,
-				dc.config.MaxConnectionsPerBroker))
+			dc.bootstrapLinks = append(dc.bootstrapLinks, dc.newBrokerLink(&Broker{ID: -1, Host: hostPort[0], Port: int32(port)}))
 		}
 	}
+}
+
+func (dc *DefaultConnector) refreshMetadata(topics []string) {
+	dc.initBootstrapLinks()
 
 	response, err := dc.sendToAllLinks(dc.links, NewMetadataRequest(topics), dc.topicMetadataValidator(topics))
 	if err != nil {
@@ -419,7 +553,7 @@ func (dc *DefaultConnector) refreshMetadata(topics []string) {
 func (dc *DefaultConnector) refreshLeaders(response *MetadataResponse) {
 	brokers := make(map[int32]*brokerLink)
 	for _, broker := range response.Brokers {
-		brokers[broker.ID] = newBrokerLink(broker, dc.config.KeepAlive, dc.config.KeepAliveTimeout, dc.config.MaxConnectionsPerBroker)
+		brokers[broker.ID] = dc.newBrokerLink(broker)
 	}
 
 	if len(brokers) != 0 && len(response.TopicsMetadata) != 0 {
@@ -654,12 +788,14 @@ func (dc *DefaultConnector) syncSendAndReceive(link *brokerLink, request Request
 
 	if err := dc.send(id, conn, request); err != nil {
 		link.Failed()
+		link.DiscardConnection(conn)
 		return nil, err
 	}
 
-	bytes, err := dc.receive(conn)
+	bytes, err := dc.receive(conn, request.Key())
 	if err != nil {
 		link.Failed()
+		link.DiscardConnection(conn)
 		return nil, err
 	}
 
@@ -668,19 +804,44 @@ func (dc *DefaultConnector) syncSendAndReceive(link *brokerLink, request Request
 	return bytes, err
 }
 
-func (dc *DefaultConnector) send(correlationID int32, conn *net.TCPConn, request Request) error {
+func (dc *DefaultConnector) send(correlationID int32, conn net.Conn, request Request) error {
 	writer := NewRequestHeader(correlationID, dc.config.ClientID, request)
 	bytes := make([]byte, writer.Size())
 	encoder := NewBinaryEncoder(bytes)
 	writer.Write(encoder)
 
+	bytes = dc.interceptors.request(request.Key(), bytes)
+
 	conn.SetWriteDeadline(time.Now().Add(dc.config.WriteTimeout))
 	_, err := conn.Write(bytes)
 	return err
 }
 
-func (dc *DefaultConnector) receive(conn *net.TCPConn) ([]byte, error) {
-	conn.SetReadDeadline(time.Now().Add(dc.config.ReadTimeout))
+// requestTimeout returns the time to wait for the response to a request with a given API key.
+// Defaults to ReadTimeout if there is no positive timeout in RequestTimeouts for it.
+func (dc *DefaultConnector) requestTimeout(apiKey int16) time.Duration {
+	if timeout := dc.config.RequestTimeouts[apiKey]; timeout > 0 {
+		return timeout
+	}
+	return dc.config.ReadTimeout
+}
+
+// WithRequestInterceptor adds a function that may inspect or replace the bytes of every request sent by this connector.
+// It also applies to produce requests a KafkaProducer using this connector sends to its brokers.
+func (dc *DefaultConnector) WithRequestInterceptor(fn func(apiKey int16, body []byte) []byte) Connector {
+	dc.interceptors.addRequest(fn)
+	return dc
+}
+
+// WithResponseInterceptor adds a function that may inspect or replace the bytes of every response received by this connector.
+// It also applies to produce responses a KafkaProducer using this connector receives from its brokers.
+func (dc *DefaultConnector) WithResponseInterceptor(fn func(apiKey int16, body []byte) []byte) Connector {
+	dc.interceptors.addResponse(fn)
+	return dc
+}
+
+func (dc *DefaultConnector) receive(conn net.Conn, apiKey int16) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(dc.requestTimeout(apiKey)))
 	header := make([]byte, 8)
 	_, err := io.ReadFull(conn, header)
 	if err != nil {
@@ -698,6 +859,7 @@ func (dc *DefaultConnector) receive(conn *net.TCPConn) ([]byte, error) {
 		return nil, err
 	}
 
+	response = dc.interceptors.response(apiKey, response)
 	return response, nil
 }
 
@@ -746,6 +908,15 @@ func (dc *DefaultConnector) consumerMetadataValidator(bytes []byte) Response {
 	return response
 }
 
+func (dc *DefaultConnector) pingValidator(bytes []byte) Response {
+	response := new(ConsumerMetadataResponse)
+	if err := dc.decode(bytes, response); err != nil {
+		return nil
+	}
+
+	return response
+}
+
 func (dc *DefaultConnector) offsetValidator(bytes []byte) Response {
 	response := new(OffsetResponse)
 	err := dc.decode(bytes, response)
@@ -766,8 +937,11 @@ func (dc *DefaultConnector) offsetValidator(bytes []byte) Response {
 type BrokerLink interface {
 	Failed()
 	Succeeded()
-	GetConnection() (int32, *net.TCPConn, error)
-	ReturnConnection(*net.TCPConn)
+	GetConnection() (int32, net.Conn, error)
+	ReturnConnection(net.Conn)
+	DiscardConnection(net.Conn)
+	ConnectionStats() ConnectionStats
+	ResetConnectionStats()
 }
 
 type brokerLink struct {
@@ -779,18 +953,30 @@ type brokerLink struct {
 	failedAttempts            int
 	correlationIds            chan int32
 	stop                      chan bool
+
+	// interceptors are the ones of the connector that created this link, nil if there is none.
+	interceptors *interceptors
+}
+
+// newBrokerLink creates a link to a given broker that uses the settings and interceptors of this connector.
+func (dc *DefaultConnector) newBrokerLink(broker *Broker) *brokerLink {
+	link := newBrokerLink(broker, dc.config.KeepAlive, dc.config.KeepAliveTimeout, dc.config.MaxConnectionsPerBroker, dc.config.TLSConfig)
+	link.interceptors = &dc.interceptors
+	return link
 }
 
-func newBrokerLink(broker *Broker, keepAlive bool, keepAliveTimeout time.Duration, maxConnectionsPerBroker int) *brokerLink {
+func newBrokerLink(broker *Broker, keepAlive bool, keepAliveTimeout time.Duration, maxConnectionsPerBroker int, tlsConfig *tls.Config) *brokerLink {
 	brokerConnect := fmt.Sprintf("%s:%d", broker.Host, broker.Port)
 	correlationIds := make(chan int32)
 	stop := make(chan bool)
 
 	go correlationIDGenerator(correlationIds, stop)
 
+	connectionPool := newConnectionPool(brokerConnect, maxConnectionsPerBroker, keepAlive, keepAliveTimeout)
+	connectionPool.tlsConfig = tlsConfig
 	return &brokerLink{
 		broker:         broker,
-		connectionPool: newConnectionPool(brokerConnect, maxConnectionsPerBroker, keepAlive, keepAliveTimeout),
+		connectionPool: connectionPool,
 		correlationIds: correlationIds,
 		stop:           stop,
 	}
@@ -811,11 +997,37 @@ func (bl *brokerLink) Succeeded() {
 	bl.lastSuccessfulConnectTime = timestamp
 }
 
-func (bl *brokerLink) ReturnConnection(conn *net.TCPConn) {
+func (bl *brokerLink) ReturnConnection(conn net.Conn) {
 	bl.connectionPool.Return(conn)
 }
 
-func (bl *brokerLink) GetConnection() (int32, *net.TCPConn, error) {
+// DiscardConnection closes a connection that failed (e.g. was dropped by the broker or a firewall) instead of returning it to the pool.
+func (bl *brokerLink) DiscardConnection(conn net.Conn) {
+	bl.connectionPool.Discard(conn)
+}
+
+// ConnectionStats returns the statistics of the connection pool to this broker.
+func (bl *brokerLink) ConnectionStats() ConnectionStats {
+	return bl.connectionPool.Stats()
+}
+
+// Broker returns the broker this link connects to.
+func (bl *brokerLink) Broker() *Broker {
+	return bl.broker
+}
+
+// CloseIdleConnections closes pooled connections to this broker that were not used for a given timeout.
+// Returns the number of closed connections.
+func (bl *brokerLink) CloseIdleConnections(timeout time.Duration) int {
+	return bl.connectionPool.CloseIdle(timeout)
+}
+
+// ResetConnectionStats zeroes the statistics of the connection pool to this broker.
+func (bl *brokerLink) ResetConnectionStats() {
+	bl.connectionPool.ResetStats()
+}
+
+func (bl *brokerLink) GetConnection() (int32, net.Conn, error) {
 	correlationID := <-bl.correlationIds
 	conn, err := bl.connectionPool.Borrow()
 	return correlationID, conn, err
@@ -838,3 +1050,68 @@ type rawResponseAndError struct {
 	link  BrokerLink
 	err   error
 }
+
+// interceptors holds the request and response interceptors of a connector. Its broker links share it, so that
+// requests sent over them by other components are intercepted as well. The zero value has no interceptors.
+type interceptors struct {
+	sync.RWMutex
+	requests  []func(int16, []byte) []byte
+	responses []func(int16, []byte) []byte
+}
+
+// linkInterceptors returns the interceptors of the connector a given link belongs to, nil if it does not belong to one.
+func linkInterceptors(link BrokerLink) *interceptors {
+	if link, ok := link.(*brokerLink); ok {
+		return link.interceptors
+	}
+	return nil
+}
+
+func (i *interceptors) addRequest(fn func(int16, []byte) []byte) {
+	inWriteLock(&i.RWMutex, func() {
+		i.requests = append(i.requests, fn)
+	})
+}
+
+func (i *interceptors) addResponse(fn func(int16, []byte) []byte) {
+	inWriteLock(&i.RWMutex, func() {
+		i.responses = append(i.responses, fn)
+	})
+}
+
+// request runs the request interceptors on a given encoded request following its length field and returns the
+// resulting request with the length field rewritten, as interceptors may change it.
+func (i *interceptors) request(apiKey int16, bytes []byte) []byte {
+	if i == nil {
+		return bytes
+	}
+	body, intercepted := i.run(&i.requests, apiKey, bytes[4:])
+	if !intercepted {
+		return bytes
+	}
+	bytes = make([]byte, 4+len(body))
+	NewBinaryEncoder(bytes).WriteInt32(int32(len(body)))
+	copy(bytes[4:], body)
+	return bytes
+}
+
+// response runs the response interceptors on the bytes of a given response following the correlation ID.
+func (i *interceptors) response(apiKey int16, bytes []byte) []byte {
+	if i == nil {
+		return bytes
+	}
+	response, _ := i.run(&i.responses, apiKey, bytes)
+	return response
+}
+
+// run runs given interceptors on given bytes in order. Returns false if there are no interceptors.
+func (i *interceptors) run(interceptors *[]func(int16, []byte) []byte, apiKey int16, bytes []byte) (intercepted []byte, ok bool) {
+	intercepted = bytes
+	inReadLock(&i.RWMutex, func() {
+		for _, interceptor := range *interceptors {
+			intercepted = interceptor(apiKey, intercepted)
+		}
+		ok = len(*interceptors) > 0
+	})
+	return intercepted, ok
+}
@@ -18,7 +18,9 @@
 package siesta
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"os"
@@ -48,8 +50,10 @@ func TestDefaultConnectorFunctional(t *testing.T) {
 
 	connector := testConnector(t)
 	testTopicMetadata(t, topicName, connector)
+	assert(t, connector.Ping(time.Second), nil)
 	testOffsetStorage(t, topicName, connector)
 	testProduce(t, topicName, numMessages, connector)
+	testListOffsets(t, topicName, numMessages, connector)
 	testConsume(t, topicName, numMessages, connector)
 	closeWithin(t, time.Second, connector)
 	//check whether closing multiple times hangs
@@ -61,6 +65,124 @@ func TestDefaultConnectorFunctional(t *testing.T) {
 	closeWithin(t, time.Second, anotherConnector)
 }
 
+func TestDefaultConnectorPingTimeout(t *testing.T) {
+	// the listener never accepts so requests are written but never answered
+	listener := startTCPListener(t)
+	defer listener.Close()
+
+	config := NewConnectorConfig()
+	config.BrokerList = []string{listener.Addr().String()}
+	config.ReadTimeout = 500 * time.Millisecond
+	connector, err := NewDefaultConnector(config)
+	assertFatal(t, err, nil)
+
+	assert(t, connector.Ping(100*time.Millisecond), ErrBrokerNotAvailable)
+}
+
+func TestDefaultConnectorRequestTimeout(t *testing.T) {
+	config := NewConnectorConfig()
+	config.ReadTimeout = time.Second
+	config.RequestTimeouts = map[int16]time.Duration{8: time.Minute, 3: 0}
+	connector := &DefaultConnector{config: *config}
+
+	assert(t, connector.requestTimeout(8), time.Minute)
+	assert(t, connector.requestTimeout(3), time.Second)
+	assert(t, connector.requestTimeout(0), time.Second)
+}
+
+func TestDefaultConnectorPingConsumerMetadata(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+
+	apiKeys := make(chan int16, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		apiKeys <- int16(binary.BigEndian.Uint16(header[4:]))
+
+		// size, correlation id, ConsumerCoordinatorNotAvailable and an empty coordinator
+		conn.Write([]byte{0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0F, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF})
+	}()
+
+	config := NewConnectorConfig()
+	config.BrokerList = []string{listener.Addr().String()}
+	connector, err := NewDefaultConnector(config)
+	assertFatal(t, err, nil)
+
+	assert(t, connector.Ping(time.Second), nil)
+	select {
+	case apiKey := <-apiKeys:
+		assert(t, apiKey, NewConsumerMetadataRequest("").Key())
+	case <-time.After(time.Second):
+		t.Error("Broker did not receive a request")
+	}
+}
+
+func TestDefaultConnectorInterceptors(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+
+	sizes := make(chan int32, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		length := int32(binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, make([]byte, length)); err != nil {
+			return
+		}
+		sizes <- length
+
+		conn.Write([]byte{0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0F, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF})
+	}()
+
+	config := NewConnectorConfig()
+	config.BrokerList = []string{listener.Addr().String()}
+	connector, err := NewDefaultConnector(config)
+	assertFatal(t, err, nil)
+
+	var originalSize int
+	var calls []string
+	responses := make(chan []byte, 1)
+	connector.WithRequestInterceptor(func(apiKey int16, body []byte) []byte {
+		calls = append(calls, "first")
+		originalSize = len(body)
+		return append(body, 0x00, 0x00)
+	}).WithRequestInterceptor(func(apiKey int16, body []byte) []byte {
+		calls = append(calls, "second")
+		assert(t, apiKey, NewConsumerMetadataRequest("").Key())
+		return body
+	}).WithResponseInterceptor(func(apiKey int16, body []byte) []byte {
+		responses <- body
+		return body
+	})
+
+	assert(t, connector.Ping(time.Second), nil)
+	assert(t, calls, []string{"first", "second"})
+	select {
+	case size := <-sizes:
+		assert(t, size, int32(originalSize+2))
+	case <-time.After(time.Second):
+		t.Fatal("Broker did not receive a request")
+	}
+	assert(t, len(<-responses), 12)
+}
+
 func testTopicMetadata(t *testing.T, topicName string, connector *DefaultConnector) {
 	metadata, err := connector.GetTopicMetadata([]string{topicName})
 	assertFatal(t, err, nil)
@@ -106,6 +228,22 @@ func testOffsetStorage(t *testing.T, topicName string, connector *DefaultConnect
 	offset, err = connector.GetOffset(group, topicName, 0)
 	assertFatal(t, err, nil)
 	assert(t, offset, targetOffset)
+
+	response, err := connector.OffsetFetch(group, map[string][]int32{topicName: {0}})
+	assertFatal(t, err, nil)
+	assert(t, response.Offsets[topicName][0].Error, ErrNoError)
+	assert(t, response.Offsets[topicName][0].Offset, targetOffset)
+}
+
+func testListOffsets(t *testing.T, topicName string, numMessages int, connector *DefaultConnector) {
+	response, err := connector.ListOffsets(map[string][]*PartitionOffsetRequestInfo{
+		topicName: {{Partition: 0, Time: LatestTime, MaxNumOffsets: 1}},
+	})
+	assertFatal(t, err, nil)
+
+	offsets := response.PartitionErrorAndOffsets[topicName][0]
+	assert(t, offsets.Error, ErrNoError)
+	assert(t, offsets.Offsets, []int64{int64(numMessages)})
 }
 
 func testProduce(t *testing.T, topicName string, numMessages int, connector *DefaultConnector) {
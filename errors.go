@@ -15,7 +15,12 @@ limitations under the License. */
 
 package siesta
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
 
 // Signals that an end of file or stream has been reached unexpectedly.
 var ErrEOF = errors.New("End of file reached")
@@ -23,6 +28,99 @@ var ErrEOF = errors.New("End of file reached")
 // Happens when a compressed message is empty.
 var ErrNoDataToUncompress = errors.New("No data to uncompress")
 
+// Happens when a message set should be compressed with a codec the producer does not support.
+var ErrUnsupportedCompressionCodec = errors.New("Unsupported compression codec")
+
+// Happens when a signed message does not carry a valid signature.
+var ErrInvalidSignature = errors.New("Message signature is invalid")
+
+// Happens when a tombstone is produced with a Signer that does not allow them.
+var ErrUnsignedTombstone = errors.New("Tombstones can not be signed")
+
+// Happens when a record is not acknowledged within a given timeout.
+var ErrSendTimeout = errors.New("Timed out while waiting for a record to be acknowledged")
+
+// Happens when records are not flushed within a given timeout.
+var ErrFlushTimeout = errors.New("Timed out while flushing records")
+
+// Happens when a ProducerInterceptor aborts sending a record.
+var ErrInterceptorAborted = errors.New("Send aborted by an interceptor")
+
+// Happens when a record is sent to a producer that is being closed.
+var ErrProducerClosing = errors.New("Producer is closing")
+
+// Happens when a MultiConnector has no route for a topic.
+var ErrNoConnectorForTopic = errors.New("No connector matches the topic")
+
+// Happens when there are no records accumulated for a requested partition.
+var ErrPartitionNotFound = errors.New("No records accumulated for partition")
+
+// ErrDrainTimeout happens when a graceful close times out before all accumulated records were acknowledged.
+type ErrDrainTimeout struct {
+	// DrainedPartitions is the number of partitions whose records were all acknowledged before the timeout.
+	DrainedPartitions int
+
+	// RemainingPartitions is the number of partitions that still had unacknowledged records.
+	RemainingPartitions int
+}
+
+func (e *ErrDrainTimeout) Error() string {
+	return fmt.Sprintf("Timed out while draining records: %d partitions drained, %d remaining", e.DrainedPartitions, e.RemainingPartitions)
+}
+
+// ErrRecordTooLarge happens when the serialized key and value of a record exceed ProducerConfig.MaxRecordSize.
+type ErrRecordTooLarge struct {
+	// Size is the serialized size of the record in bytes.
+	Size int
+
+	// MaxRecordSize is the limit the record exceeded.
+	MaxRecordSize int
+}
+
+func (e *ErrRecordTooLarge) Error() string {
+	return fmt.Sprintf("Record of %d bytes exceeds the maximum record size of %d bytes", e.Size, e.MaxRecordSize)
+}
+
+// FlushError happens when records fail while the producer is flushed.
+type FlushError struct {
+	// Failed holds the metadata of every record that failed, in order of completion.
+	Failed []*RecordMetadata
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf("%d records failed while flushing, first error: %s", len(e.Failed), e.Failed[0].Error)
+}
+
+// WarmUpError happens when the metadata for some topics could not be fetched while warming up the metadata cache.
+type WarmUpError struct {
+	// Failed holds the error for every topic that failed, keyed by topic.
+	Failed map[string]error
+}
+
+func (e *WarmUpError) Error() string {
+	topics := make([]string, 0, len(e.Failed))
+	for topic := range e.Failed {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	problems := make([]string, len(topics))
+	for i, topic := range topics {
+		problems[i] = fmt.Sprintf("%s: %s", topic, e.Failed[topic])
+	}
+	return fmt.Sprintf("Could not warm up metadata for %d topics: %s", len(topics), strings.Join(problems, "; "))
+}
+
+// ErrInvalidConfig happens when a ProducerConfig does not validate.
+type ErrInvalidConfig struct {
+	// Problems describes every invalid setting, one sentence each.
+	Problems []string
+}
+
+func (e *ErrInvalidConfig) Error() string {
+	return fmt.Sprintf("Invalid config: %s", strings.Join(e.Problems, " "))
+}
+
 // A mapping for Kafka error code 0.
 var ErrNoError = errors.New("No error - it worked!")
 
@@ -74,6 +172,12 @@ var ErrConsumerCoordinatorNotAvailableCode = errors.New("Offsets topic has not y
 // A mapping for Kafka error code 15.
 var ErrNotCoordinatorForConsumerCode = errors.New("There is no coordinator for this consumer.")
 
+// A mapping for Kafka error code 19.
+var ErrNotEnoughReplicas = errors.New("The number of in-sync replicas is lower than required by the topic.")
+
+// A mapping for Kafka error code 20.
+var ErrNotEnoughReplicasAfterAppend = errors.New("The message was written to the log, but with fewer in-sync replicas than required.")
+
 // Mapping between Kafka error codes and actual error messages.
 var BrokerErrors = map[int16]error{
 	-1: ErrUnknown,
@@ -93,4 +197,15 @@ var BrokerErrors = map[int16]error{
 	14: ErrOffsetsLoadInProgressCode,
 	15: ErrConsumerCoordinatorNotAvailableCode,
 	16: ErrNotCoordinatorForConsumerCode,
+	19: ErrNotEnoughReplicas,
+	20: ErrNotEnoughReplicasAfterAppend,
+}
+
+// Broker errors a produce request may succeed after if it is sent again, possibly to a new leader.
+var retriableProduceErrors = map[error]bool{
+	ErrLeaderNotAvailable:           true,
+	ErrNotLeaderForPartition:        true,
+	ErrRequestTimedOut:              true,
+	ErrNotEnoughReplicas:            true,
+	ErrNotEnoughReplicasAfterAppend: true,
 }
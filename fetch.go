@@ -146,6 +146,27 @@ func (fr *FetchResponse) CollectMessages(collector func(topic string, partition
 	return nil
 }
 
+// TotalBytes returns the total length of all keys and values in this FetchResponse.
+// Messages of compressed message sets are counted uncompressed, partitions with errors are counted as well.
+func (fr *FetchResponse) TotalBytes() int {
+	total := 0
+	for _, partitionAndData := range fr.Data {
+		for _, data := range partitionAndData {
+			for _, messageAndOffset := range data.Messages {
+				if messageAndOffset.Message.Nested != nil {
+					for _, nested := range messageAndOffset.Message.Nested {
+						total += len(nested.Message.Key) + len(nested.Message.Value)
+					}
+				} else {
+					total += len(messageAndOffset.Message.Key) + len(messageAndOffset.Message.Value)
+				}
+			}
+		}
+	}
+
+	return total
+}
+
 // PartitionFetchInfo contains information about what partition to fetch, what offset to fetch from and the maximum bytes to include in the message set for this partition.
 type PartitionFetchInfo struct {
 	Partition int32
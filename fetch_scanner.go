@@ -0,0 +1,281 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// FetchResponseScanner decodes a FetchResponse incrementally from an io.Reader.
+// Unlike FetchResponse.Read it does not need the whole response in memory and yields messages one at a time
+// as they are received, so processing can start before the rest of the response arrives.
+// Scanning stops at the first partition that contains an error or if the response is malformed, check Err afterwards.
+type FetchResponseScanner struct {
+	reader        *bufio.Reader
+	buffer        []byte
+	correlationID int32
+
+	topicsLeft     int32
+	partitionsLeft int32
+	setLeft        int64
+	topic          string
+	partition      int32
+	highwaterMark  int64
+
+	nested  []*MessageAndOffset
+	message *MessageAndMetadata
+	err     error
+}
+
+// NewFetchResponseScanner creates a new FetchResponseScanner reading a single fetch response from a given io.Reader.
+// The reader should be positioned at the start of the response as it is sent by the broker, i.e. at the size field.
+// The scanner never reads past the end of the response, so the reader may be a connection with more responses to follow.
+func NewFetchResponseScanner(reader io.Reader) (*FetchResponseScanner, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(header))
+	scanner := &FetchResponseScanner{
+		reader:        bufio.NewReader(io.LimitReader(reader, size-4)),
+		buffer:        make([]byte, 8),
+		correlationID: int32(binary.BigEndian.Uint32(header[4:])),
+	}
+
+	scanner.topicsLeft, scanner.err = scanner.readInt32()
+	return scanner, scanner.err
+}
+
+// CorrelationID returns the correlation id of the response being scanned.
+func (fs *FetchResponseScanner) CorrelationID() int32 {
+	return fs.correlationID
+}
+
+// HighwaterMarkOffset returns the highwater mark offset of the partition the current message belongs to.
+func (fs *FetchResponseScanner) HighwaterMarkOffset() int64 {
+	return fs.highwaterMark
+}
+
+// Scan advances the scanner to the next message which will then be available through Message.
+// Returns false when there are no more messages or an error occurred.
+func (fs *FetchResponseScanner) Scan() bool {
+	fs.message = nil
+	for fs.err == nil {
+		if len(fs.nested) > 0 {
+			fs.setMessage(fs.nested[0].Offset, fs.nested[0].Message)
+			fs.nested = fs.nested[1:]
+			return true
+		}
+
+		if fs.setLeft > 0 {
+			if fs.nextMessage() {
+				return true
+			}
+			continue
+		}
+
+		if fs.partitionsLeft > 0 {
+			fs.partitionsLeft--
+			fs.readPartitionHeader()
+			continue
+		}
+
+		if fs.topicsLeft > 0 {
+			fs.topicsLeft--
+			fs.readTopicHeader()
+			continue
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// Message returns the most recent message read by Scan.
+func (fs *FetchResponseScanner) Message() *MessageAndMetadata {
+	return fs.message
+}
+
+// Err returns the first error encountered by the scanner.
+// This is either a broker error for one of the partitions or an error reading or decoding the response.
+func (fs *FetchResponseScanner) Err() error {
+	return fs.err
+}
+
+func (fs *FetchResponseScanner) readTopicHeader() {
+	if fs.topic, fs.err = fs.readString(); fs.err != nil {
+		return
+	}
+	fs.partitionsLeft, fs.err = fs.readInt32()
+}
+
+func (fs *FetchResponseScanner) readPartitionHeader() {
+	if fs.partition, fs.err = fs.readInt32(); fs.err != nil {
+		return
+	}
+
+	errCode, err := fs.readInt16()
+	if err != nil {
+		fs.err = err
+		return
+	}
+
+	// the rest of the header is read even for failed partitions, so that it is never misparsed
+	if fs.highwaterMark, fs.err = fs.readInt64(); fs.err != nil {
+		return
+	}
+	setLength, err := fs.readInt32()
+	if err != nil {
+		fs.err = err
+		return
+	}
+
+	brokerErr, known := BrokerErrors[errCode]
+	if !known {
+		brokerErr = ErrUnknown
+	}
+	if brokerErr != ErrNoError {
+		fs.err = brokerErr
+		return
+	}
+	fs.setLeft = int64(setLength)
+}
+
+// nextMessage reads a single MessageAndOffset from the current message set.
+// Brokers may return a partial message at the end of a message set, it is skipped the same way ReadMessageSet does.
+func (fs *FetchResponseScanner) nextMessage() bool {
+	messageAndOffset, err := fs.readMessageAndOffset()
+	if err == ErrEOF {
+		fs.skipMessageSet()
+		return false
+	}
+	if err != nil {
+		fs.err = err
+		return false
+	}
+
+	if messageAndOffset.Message.Nested != nil {
+		fs.nested = messageAndOffset.Message.Nested
+		return false
+	}
+
+	fs.setMessage(messageAndOffset.Offset, messageAndOffset.Message)
+	return true
+}
+
+func (fs *FetchResponseScanner) readMessageAndOffset() (*MessageAndOffset, error) {
+	if fs.setLeft < 12 {
+		return nil, ErrEOF
+	}
+
+	offset, err := fs.readInt64()
+	if err != nil {
+		return nil, err
+	}
+	length, err := fs.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	fs.setLeft -= 12
+
+	if length < 0 || int64(length) > fs.setLeft {
+		return nil, ErrEOF
+	}
+
+	raw := make([]byte, length)
+	if _, err := io.ReadFull(fs.reader, raw); err != nil {
+		return nil, ErrEOF
+	}
+	fs.setLeft -= int64(length)
+
+	message := new(Message)
+	if decodingErr := message.Read(NewBinaryDecoder(raw)); decodingErr != nil {
+		return nil, decodingErr.Error()
+	}
+
+	return &MessageAndOffset{Offset: offset, Message: message}, nil
+}
+
+// skipMessageSet discards whatever is left of the current message set, tolerating a response cut short.
+func (fs *FetchResponseScanner) skipMessageSet() {
+	io.CopyN(ioutil.Discard, fs.reader, fs.setLeft)
+	fs.setLeft = 0
+}
+
+func (fs *FetchResponseScanner) setMessage(offset int64, message *Message) {
+	fs.message = &MessageAndMetadata{
+		Topic:     fs.topic,
+		Partition: fs.partition,
+		Offset:    offset,
+		Key:       message.Key,
+		Value:     message.Value,
+	}
+}
+
+func (fs *FetchResponseScanner) read(length int) ([]byte, error) {
+	if _, err := io.ReadFull(fs.reader, fs.buffer[:length]); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, ErrEOF
+		}
+		return nil, err
+	}
+	return fs.buffer[:length], nil
+}
+
+func (fs *FetchResponseScanner) readInt16() (int16, error) {
+	bytes, err := fs.read(2)
+	if err != nil {
+		return -1, err
+	}
+	return int16(binary.BigEndian.Uint16(bytes)), nil
+}
+
+func (fs *FetchResponseScanner) readInt32() (int32, error) {
+	bytes, err := fs.read(4)
+	if err != nil {
+		return -1, err
+	}
+	return int32(binary.BigEndian.Uint32(bytes)), nil
+}
+
+func (fs *FetchResponseScanner) readInt64() (int64, error) {
+	bytes, err := fs.read(8)
+	if err != nil {
+		return -1, err
+	}
+	return int64(binary.BigEndian.Uint64(bytes)), nil
+}
+
+func (fs *FetchResponseScanner) readString() (string, error) {
+	length, err := fs.readInt16()
+	if err != nil {
+		return "", err
+	}
+	if length < 1 {
+		return "", nil
+	}
+
+	value := make([]byte, length)
+	if _, err := io.ReadFull(fs.reader, value); err != nil {
+		return "", ErrEOF
+	}
+	return string(value), nil
+}
@@ -0,0 +1,140 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type testFetchPartition struct {
+	partition int32
+	errCode   int16
+	messages  [][]byte
+	trailing  []byte
+}
+
+// testFetchResponseBytes builds a fetch response as it is sent by the broker, including the size and correlation id.
+func testFetchResponseBytes(correlationID int32, topics []string, partitions [][]testFetchPartition) []byte {
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.BigEndian, correlationID)
+	binary.Write(body, binary.BigEndian, int32(len(topics)))
+	for i, topic := range topics {
+		binary.Write(body, binary.BigEndian, int16(len(topic)))
+		body.WriteString(topic)
+		binary.Write(body, binary.BigEndian, int32(len(partitions[i])))
+		for _, partition := range partitions[i] {
+			messageSet := new(bytes.Buffer)
+			for offset, value := range partition.messages {
+				message := new(bytes.Buffer)
+				binary.Write(message, binary.BigEndian, int32(0)) // crc is not verified
+				message.Write([]byte{0, 0})
+				binary.Write(message, binary.BigEndian, int32(-1))
+				binary.Write(message, binary.BigEndian, int32(len(value)))
+				message.Write(value)
+
+				binary.Write(messageSet, binary.BigEndian, int64(offset))
+				binary.Write(messageSet, binary.BigEndian, int32(message.Len()))
+				messageSet.Write(message.Bytes())
+			}
+			messageSet.Write(partition.trailing)
+
+			binary.Write(body, binary.BigEndian, partition.partition)
+			binary.Write(body, binary.BigEndian, partition.errCode)
+			binary.Write(body, binary.BigEndian, int64(len(partition.messages)))
+			binary.Write(body, binary.BigEndian, int32(messageSet.Len()))
+			body.Write(messageSet.Bytes())
+		}
+	}
+
+	response := new(bytes.Buffer)
+	binary.Write(response, binary.BigEndian, int32(body.Len()))
+	response.Write(body.Bytes())
+	return response.Bytes()
+}
+
+func TestFetchResponseScanner(t *testing.T) {
+	response := testFetchResponseBytes(5, []string{"logs1", "logs2"}, [][]testFetchPartition{
+		{
+			{partition: 0, messages: [][]byte{[]byte("foo"), []byte("bar")}},
+			{partition: 1},
+		},
+		{
+			{partition: 2, messages: [][]byte{[]byte("baz")}, trailing: []byte{0x00, 0x00, 0x00}},
+		},
+	})
+	next := []byte{0x01, 0x02, 0x03}
+	reader := bytes.NewReader(append(response, next...))
+
+	scanner, err := NewFetchResponseScanner(reader)
+	checkErr(t, err)
+	assert(t, scanner.CorrelationID(), int32(5))
+
+	var messages []*MessageAndMetadata
+	for scanner.Scan() {
+		messages = append(messages, scanner.Message())
+	}
+	checkErr(t, scanner.Err())
+	assert(t, scanner.HighwaterMarkOffset(), int64(1))
+
+	assertFatal(t, len(messages), 3)
+	assert(t, messages[0], &MessageAndMetadata{Topic: "logs1", Partition: 0, Offset: 0, Value: []byte("foo")})
+	assert(t, messages[1], &MessageAndMetadata{Topic: "logs1", Partition: 0, Offset: 1, Value: []byte("bar")})
+	assert(t, messages[2], &MessageAndMetadata{Topic: "logs2", Partition: 2, Offset: 0, Value: []byte("baz")})
+
+	// the scanner should never read past the end of its response
+	assert(t, reader.Len(), len(next))
+}
+
+func TestFetchResponseScannerErrors(t *testing.T) {
+	response := testFetchResponseBytes(0, []string{"logs"}, [][]testFetchPartition{
+		{
+			{partition: 0, messages: [][]byte{[]byte("foo")}},
+			{partition: 1, errCode: 3, messages: [][]byte{[]byte("bar")}},
+		},
+	})
+	scanner, err := NewFetchResponseScanner(bytes.NewReader(response))
+	checkErr(t, err)
+	assert(t, scanner.Scan(), true)
+	assert(t, scanner.Message().Value, []byte("foo"))
+	assert(t, scanner.Scan(), false)
+	assert(t, scanner.Err(), ErrUnknownTopicOrPartition)
+
+	// error codes this client does not know must not be mistaken for success
+	response = testFetchResponseBytes(0, []string{"logs"}, [][]testFetchPartition{
+		{{partition: 0, errCode: 999, messages: [][]byte{[]byte("foo")}}},
+	})
+	scanner, err = NewFetchResponseScanner(bytes.NewReader(response))
+	checkErr(t, err)
+	assert(t, scanner.Scan(), false)
+	assert(t, scanner.Err(), ErrUnknown)
+	assert(t, scanner.HighwaterMarkOffset(), int64(1))
+
+	// cut in the middle of the second partition header
+	response = testFetchResponseBytes(0, []string{"logs"}, [][]testFetchPartition{
+		{{partition: 0}, {partition: 1}},
+	})
+	response = response[:len(response)-10]
+	binary.BigEndian.PutUint32(response, uint32(len(response)-4))
+	scanner, err = NewFetchResponseScanner(bytes.NewReader(response))
+	checkErr(t, err)
+	assert(t, scanner.Scan(), false)
+	assert(t, scanner.Err(), ErrEOF)
+
+	_, err = NewFetchResponseScanner(bytes.NewReader([]byte{0x00, 0x00}))
+	assertNot(t, err, nil)
+}
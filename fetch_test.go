@@ -62,6 +62,7 @@ func TestFetchRequest(t *testing.T) {
 func TestFetchResponse(t *testing.T) {
 	emptyFetchResponse := new(FetchResponse)
 	decode(t, emptyFetchResponse, emptyFetchResponseBytes)
+	assert(t, emptyFetchResponse.TotalBytes(), 0)
 
 	singleFetchResponse := new(FetchResponse)
 	decode(t, singleFetchResponse, singleFetchResponseBytes)
@@ -131,4 +132,6 @@ func testGoodFetchResponse(t *testing.T, response *FetchResponse) {
 	assert(t, message.Offset, int64(1000))
 	assert(t, message.Key, []byte{0xAA, 0xAA, 0xAA, 0xAA})
 	assert(t, message.Value, []byte{0xBB, 0xBB, 0xBB, 0xBB})
+	assert(t, message.Size(), 72)
+	assert(t, response.TotalBytes(), 8)
 }
@@ -0,0 +1,57 @@
+package siesta
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// JSONSerializer marshals a given value with encoding/json.
+// A nil value is serialized to nil, i.e. a tombstone, like with ByteSerializer. A typed nil pointer is not nil here
+// and is serialized to "null", as are zero values to their JSON representation, e.g. 0 or "".
+func JSONSerializer(value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(value)
+}
+
+// JSONDeserializer unmarshals given bytes with encoding/json into the generic types it uses, e.g. map[string]interface{} for objects.
+// Nil or empty data (a tombstone) is deserialized to nil, as is "null".
+func JSONDeserializer(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// NewTypedJSONDeserializer creates a Deserializer that unmarshals into a new value of the type of a given example
+// and returns a pointer to it, so callers only need a single type assertion. The result is always a *T, where T is
+// the type of the example with one pointer level removed, e.g. a *Event for both Event{} and &Event{}.
+// Nil or empty data (a tombstone) is deserialized to a nil *T, "null" to a pointer to the zero value of T.
+// Panics if the example is nil, as there is no type to deserialize into then.
+func NewTypedJSONDeserializer(example interface{}) Deserializer {
+	if example == nil {
+		panic("NewTypedJSONDeserializer needs a non-nil example value to take the type from")
+	}
+	valueType := reflect.TypeOf(example)
+	if valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	return func(data []byte) (interface{}, error) {
+		if len(data) == 0 {
+			return reflect.Zero(reflect.PtrTo(valueType)).Interface(), nil
+		}
+
+		value := reflect.New(valueType)
+		if err := json.Unmarshal(data, value.Interface()); err != nil {
+			return nil, err
+		}
+		return value.Interface(), nil
+	}
+}
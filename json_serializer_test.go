@@ -0,0 +1,85 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import "testing"
+
+type jsonTestEvent struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestJSONSerializer(t *testing.T) {
+	data, err := JSONSerializer(jsonTestEvent{Name: "siesta", Count: 2})
+	checkErr(t, err)
+	assert(t, string(data), `{"name":"siesta","count":2}`)
+
+	data, err = JSONSerializer(nil)
+	checkErr(t, err)
+	assert(t, data, []byte(nil))
+
+	data, err = JSONSerializer((*jsonTestEvent)(nil))
+	checkErr(t, err)
+	assert(t, string(data), "null")
+
+	_, err = JSONSerializer(make(chan int))
+	assertNot(t, err, nil)
+}
+
+func TestJSONDeserializer(t *testing.T) {
+	value, err := JSONDeserializer([]byte(`{"name":"siesta","count":2}`))
+	checkErr(t, err)
+	assert(t, value, map[string]interface{}{"name": "siesta", "count": 2.0})
+
+	value, err = JSONDeserializer(nil)
+	checkErr(t, err)
+	assert(t, value, nil)
+
+	_, err = JSONDeserializer([]byte("{"))
+	assertNot(t, err, nil)
+}
+
+func TestTypedJSONDeserializer(t *testing.T) {
+	deserializer := NewTypedJSONDeserializer(jsonTestEvent{})
+
+	value, err := deserializer([]byte(`{"name":"siesta","count":2}`))
+	checkErr(t, err)
+	assert(t, value, &jsonTestEvent{Name: "siesta", Count: 2})
+
+	value, err = deserializer(nil)
+	checkErr(t, err)
+	assert(t, value, (*jsonTestEvent)(nil))
+
+	value, err = deserializer([]byte("null"))
+	checkErr(t, err)
+	assert(t, value, &jsonTestEvent{})
+
+	_, err = deserializer([]byte(`{"count":"two"}`))
+	assertNot(t, err, nil)
+
+	// a pointer example yields the same type
+	value, err = NewTypedJSONDeserializer(&jsonTestEvent{})([]byte(`{"name":"siesta"}`))
+	checkErr(t, err)
+	assert(t, value, &jsonTestEvent{Name: "siesta"})
+}
+
+func TestTypedJSONDeserializerNilExample(t *testing.T) {
+	defer func() {
+		assertNot(t, recover(), nil)
+	}()
+	NewTypedJSONDeserializer(nil)
+	t.Error("A nil example should panic")
+}
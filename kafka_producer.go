@@ -1,8 +1,10 @@
 package siesta
 
 import (
+	"crypto/rand"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/ninsen/cfg"
@@ -13,10 +15,37 @@ type ProducerRecord struct {
 	Key   interface{}
 	Value interface{}
 
+	// TraceID correlates this record with the RecordMetadata it is completed with, which carries the same value.
+	// The v0 message format used by this producer has no headers, so it is not sent to Kafka.
+	TraceID [16]byte
+
 	partition    int32
 	encodedKey   []byte
 	encodedValue []byte
 	metadataChan chan *RecordMetadata
+	callback     func(*RecordMetadata)
+	interceptors []ProducerInterceptor
+}
+
+// complete hands given metadata to the interceptors and then to the channel or the callback of this record.
+// Panics in callbacks are recovered and logged with a given tag so that they never crash the goroutine completing the record.
+func (pr *ProducerRecord) complete(tag interface{}, metadata *RecordMetadata) {
+	metadata.TraceID = pr.TraceID
+	pr.acknowledge(tag, metadata)
+	if pr.metadataChan != nil {
+		pr.metadataChan <- metadata
+		return
+	}
+	if pr.callback == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			Errorf(tag, "Send callback for %s:%d panicked: %v", metadata.Topic, metadata.Partition, r)
+		}
+	}()
+	pr.callback(metadata)
 }
 
 type RecordMetadata struct {
@@ -24,10 +53,37 @@ type RecordMetadata struct {
 	Topic     string
 	Partition int32
 	Error     error
+
+	// TraceID is the TraceID of the record this metadata belongs to.
+	TraceID [16]byte
+}
+
+// NewTraceID returns a random 16 byte trace ID for ProducerRecord.TraceID.
+func NewTraceID() (id [16]byte) {
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Sprintf("Could not generate a trace ID: %s", err))
+	}
+	return id
+}
+
+// PartitionInfo describes a single partition of a topic as of the last metadata refresh.
+type PartitionInfo struct {
+	Topic     string
+	Partition int32
+
+	// Leader is the ID of the broker leading this partition, -1 if there is no leader.
+	Leader int32
+
+	Replicas       []int32
+	InSyncReplicas []int32
+}
+
+// TopicPartition identifies a single partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
 }
 
-type PartitionInfo struct{}
-type Metric struct{}
 type ProducerConfig struct {
 	MetadataFetchTimeout time.Duration
 	MetadataExpire       time.Duration
@@ -38,7 +94,51 @@ type ProducerConfig struct {
 	Linger               time.Duration
 	Retries              int
 	RetryBackoff         time.Duration
-	BlockOnBufferFull    bool
+
+	// RetryBackoffMax caps the backoff between retries, which starts at RetryBackoff and doubles with every attempt.
+	// Batches failing with a retriable error are sent again up to Retries times, waiting a random time up to the current
+	// backoff. Other errors fail the records right away. The backoff is not capped if RetryBackoffMax is not positive.
+	RetryBackoffMax   time.Duration
+	BlockOnBufferFull bool
+
+	// ValueSigner signs every serialized value with the topic of its record, e.g. NewHMACSHA256Signer. Values are not signed if nil.
+	ValueSigner Signer
+
+	// MetricsReporter receives producer metrics as they are updated. Defaults to a no-op reporter.
+	MetricsReporter MetricsReporter
+
+	// MetadataChannelBuffer is the capacity of the channel returned by Send. Values below 1 are treated as 1.
+	MetadataChannelBuffer int
+
+	// MaxOutstandingRequests is the maximum number of produce requests per broker that were sent but not yet acknowledged.
+	// Batches for a broker that reached this limit are held back until one of its requests is acknowledged,
+	// batches for other brokers are sent as usual. 0 disables the limit.
+	MaxOutstandingRequests int
+
+	// AckTimeout is the time to wait for the response to a produce request, used instead of ReadTimeout for these.
+	// It should be longer than AckTimeoutMs, the time the broker waits for replicas to acknowledge the records.
+	// ReadTimeout is used if it is not positive.
+	AckTimeout time.Duration
+
+	// Interceptors see every record before it is serialized and its metadata once it is completed, in order.
+	Interceptors []ProducerInterceptor
+
+	// IdleConnectionTimeout is the time after which unused connections to brokers are closed, see NetworkClientConfig.
+	// 0 keeps idle connections open.
+	IdleConnectionTimeout time.Duration
+
+	// MaxRecordSize limits the serialized key and value of a single record in bytes. Larger records fail with
+	// *ErrRecordTooLarge before they are batched. Defaults to MaxRequestSize if 0, must not exceed it otherwise.
+	MaxRecordSize int
+
+	// RequestTimeouts overrides ReadTimeout and AckTimeout for responses to requests with a given Kafka API key,
+	// see SelectorConfig. Keys without a positive timeout use the defaults.
+	RequestTimeouts map[int16]time.Duration
+
+	// RetryFilter, if set, is asked whether a batch failing with a given retriable error should be sent again.
+	// Errors the producer does not consider retriable are never retried, whatever it returns. It is called from the
+	// network client and must not block.
+	RetryFilter func(err error) bool
 
 	ClientID        string
 	MaxRequests     int
@@ -51,9 +151,84 @@ type ProducerConfig struct {
 	BrokerList      []string
 }
 
-func NewProducerConfig() *ProducerConfig {
-	return &ProducerConfig{
+// Option changes a single setting of a ProducerConfig.
+type Option func(*ProducerConfig)
+
+// WithBrokers sets the bootstrap brokers as host:port pairs.
+func WithBrokers(brokers ...string) Option {
+	return func(config *ProducerConfig) {
+		config.BrokerList = brokers
+	}
+}
+
+// WithClientID sets the client id sent with every request.
+func WithClientID(id string) Option {
+	return func(config *ProducerConfig) {
+		config.ClientID = id
+	}
+}
+
+// WithBatchSize sets the maximum number of records per batch.
+func WithBatchSize(n int) Option {
+	return func(config *ProducerConfig) {
+		config.BatchSize = n
+	}
+}
+
+// WithCompression sets the compression type, e.g. gzip.
+func WithCompression(algo string) Option {
+	return func(config *ProducerConfig) {
+		config.CompressionType = algo
+	}
+}
+
+// WithLingerMs sets how long records are accumulated before a batch is sent.
+func WithLingerMs(ms int64) Option {
+	return func(config *ProducerConfig) {
+		config.Linger = time.Duration(ms) * time.Millisecond
+	}
+}
+
+// WithRequiredAcks sets the number of acknowledgements the leader must receive before responding.
+func WithRequiredAcks(acks int) Option {
+	return func(config *ProducerConfig) {
+		config.RequiredAcks = acks
+	}
+}
+
+// WithMaxRequestSize sets the maximum size of a produce request in bytes.
+func WithMaxRequestSize(size int) Option {
+	return func(config *ProducerConfig) {
+		config.MaxRequestSize = size
+	}
+}
+
+// WithMaxRecordSize sets the maximum serialized size of a single record in bytes.
+func WithMaxRecordSize(size int) Option {
+	return func(config *ProducerConfig) {
+		config.MaxRecordSize = size
+	}
+}
+
+// WithRequestTimeouts sets the read timeouts for responses to requests by Kafka API key.
+func WithRequestTimeouts(timeouts map[int16]time.Duration) Option {
+	return func(config *ProducerConfig) {
+		config.RequestTimeouts = timeouts
+	}
+}
+
+// WithMetricsReporter sets the MetricsReporter receiving producer metrics.
+func WithMetricsReporter(reporter MetricsReporter) Option {
+	return func(config *ProducerConfig) {
+		config.MetricsReporter = reporter
+	}
+}
+
+// NewProducerConfig creates a ProducerConfig with default values and applies given options to it in order.
+func NewProducerConfig(opts ...Option) *ProducerConfig {
+	config := &ProducerConfig{
 		BatchSize:       1000,
+		MaxRequestSize:  1024 * 1024,
 		ClientID:        "siesta",
 		MaxRequests:     10,
 		SendRoutines:    10,
@@ -63,7 +238,60 @@ func NewProducerConfig() *ProducerConfig {
 		RequiredAcks:    1,
 		AckTimeoutMs:    1000,
 		Linger:          1 * time.Second,
+
+		MetadataChannelBuffer:  1,
+		MaxOutstandingRequests: 5,
+		AckTimeout:             30 * time.Second,
+		IdleConnectionTimeout:  9 * time.Minute,
+		RetryBackoff:           100 * time.Millisecond,
+		RetryBackoffMax:        time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// Validate checks that all settings of this ProducerConfig are in a usable range.
+// Returns an *ErrInvalidConfig listing every problem found, nil if there are none.
+func (pc *ProducerConfig) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(pc.BatchSize >= 1, "BatchSize cannot be less than 1.")
+	check(pc.MaxRequestSize >= 0, "MaxRequestSize cannot be less than 0.")
+	check(pc.MaxRecordSize >= 0, "MaxRecordSize cannot be less than 0.")
+	check(pc.MaxRequestSize == 0 || pc.MaxRecordSize <= pc.MaxRequestSize, "MaxRecordSize cannot be greater than MaxRequestSize.")
+	check(pc.TotalMemorySize >= 0, "TotalMemorySize cannot be less than 0.")
+	check(pc.Linger >= time.Millisecond, "Linger must be at least 1ms.")
+	check(pc.MetadataExpire >= 0, "MetadataExpire cannot be less than 0.")
+	check(pc.ClientID != "", "ClientID cannot be empty.")
+	check(pc.MaxRequests >= 0, "MaxRequests cannot be less than 0.")
+	check(pc.SendRoutines >= 1, "SendRoutines cannot be less than 1.")
+	check(pc.RequiredAcks == 0 || pc.ReceiveRoutines >= 1, "ReceiveRoutines cannot be less than 1 unless RequiredAcks is 0.")
+	check(pc.ReadTimeout >= time.Millisecond, "ReadTimeout must be at least 1ms.")
+	check(pc.WriteTimeout >= time.Millisecond, "WriteTimeout must be at least 1ms.")
+	check(pc.RequiredAcks >= -1, "RequiredAcks cannot be less than -1.")
+	check(pc.AckTimeoutMs >= 0, "AckTimeoutMs cannot be less than 0.")
+	check(pc.AckTimeout >= 0, "AckTimeout cannot be less than 0.")
+	check(pc.Retries >= 0, "Retries cannot be less than 0.")
+	check(pc.RetryBackoff >= 0, "RetryBackoff cannot be less than 0.")
+	check(pc.RetryBackoffMax >= 0, "RetryBackoffMax cannot be less than 0.")
+	check(pc.IdleConnectionTimeout >= 0, "IdleConnectionTimeout cannot be less than 0.")
+	check(pc.MetadataChannelBuffer >= 0, "MetadataChannelBuffer cannot be less than 0.")
+	check(pc.MaxOutstandingRequests >= 0, "MaxOutstandingRequests cannot be less than 0.")
+	_, supported := compressionCodecFor(pc.CompressionType)
+	check(supported, "CompressionType must be one of none, gzip or snappy.")
+
+	if len(problems) > 0 {
+		return &ErrInvalidConfig{Problems: problems}
 	}
+	return nil
 }
 
 type Serializer func(interface{}) ([]byte, error)
@@ -108,37 +336,53 @@ type Producer interface {
 }
 
 type KafkaProducer struct {
-	config          *ProducerConfig
-	time            time.Time
-	partitioner     Partitioner
-	keySerializer   Serializer
-	valueSerializer Serializer
-	metrics         map[string]Metric
-	accumulator     *RecordAccumulator
-	metricTags      map[string]string
-	connector       Connector
-	metadata        *Metadata
-	RecordsMetadata chan *RecordMetadata
-}
-
-func NewKafkaProducer(config *ProducerConfig, keySerializer Serializer, valueSerializer Serializer, connector Connector) *KafkaProducer {
+	config           *ProducerConfig
+	time             time.Time
+	partitioner      Partitioner
+	partitioners     map[string]Partitioner
+	partitionersLock sync.RWMutex
+	keySerializer    Serializer
+	valueSerializer  Serializer
+	metrics          *producerMetrics
+	pending          *pendingRecords
+	accumulator      *RecordAccumulator
+	metricTags       map[string]string
+	connector        Connector
+	metadata         *Metadata
+	RecordsMetadata  chan *RecordMetadata
+}
+
+// NewKafkaProducer creates a KafkaProducer with a given config. Returns an *ErrInvalidConfig if the config does not validate.
+func NewKafkaProducer(config *ProducerConfig, keySerializer Serializer, valueSerializer Serializer, connector Connector) (*KafkaProducer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	log.Println("Starting the Kafka producer")
 	producer := &KafkaProducer{}
 	producer.config = config
 	producer.time = time.Now()
-	producer.metrics = make(map[string]Metric)
+	producer.pending = newPendingRecords()
 	producer.partitioner = NewHashPartitioner()
+	producer.partitioners = make(map[string]Partitioner)
 	producer.keySerializer = keySerializer
 	producer.valueSerializer = valueSerializer
 	producer.connector = connector
 	producer.metadata = NetMetadata(connector, config.MetadataExpire)
-	metricTags := make(map[string]string)
-
-	networkClientConfig := NetworkClientConfig{}
+	producer.metricTags = map[string]string{"client-id": config.ClientID}
+	producer.metrics = newProducerMetrics(config.MetricsReporter, producer.metricTags)
+
+	networkClientConfig := NetworkClientConfig{
+		IdleConnectionTimeout: config.IdleConnectionTimeout,
+		RequestTimeouts:       config.RequestTimeouts,
+		metrics:               producer.metrics,
+		pending:               producer.pending,
+	}
 	client := NewNetworkClient(networkClientConfig, connector, config)
 
 	accumulatorConfig := &RecordAccumulatorConfig{
 		batchSize:         config.BatchSize,
+		maxRequestSize:    config.MaxRequestSize,
 		totalMemorySize:   config.TotalMemorySize,
 		compressionType:   config.CompressionType,
 		linger:            config.Linger,
@@ -146,14 +390,16 @@ func NewKafkaProducer(config *ProducerConfig, keySerializer Serializer, valueSer
 		blockOnBufferFull: config.BlockOnBufferFull,
 		metrics:           producer.metrics,
 		time:              producer.time,
-		metricTags:        metricTags,
+		metricTags:        producer.metricTags,
 		networkClient:     client,
+		pending:           producer.pending,
+		batchFlushed:      producer.batchFlushed,
 	}
 	producer.accumulator = NewRecordAccumulator(accumulatorConfig, producer.RecordsMetadata)
 
 	log.Println("Kafka producer started")
 
-	return producer
+	return producer, nil
 }
 
 func ProducerConfigFromFile(filename string) (*ProducerConfig, error) {
@@ -181,6 +427,12 @@ func ProducerConfigFromFile(filename string) (*ProducerConfig, error) {
 	if err := setInt32Config(&producerConfig.AckTimeoutMs, c["timeout.ms"]); err != nil {
 		return nil, err
 	}
+	if err := setDurationConfig(&producerConfig.AckTimeout, c["ack.timeout"]); err != nil {
+		return nil, err
+	}
+	if err := setDurationConfig(&producerConfig.IdleConnectionTimeout, c["connections.max.idle"]); err != nil {
+		return nil, err
+	}
 	if err := setDurationConfig(&producerConfig.Linger, c["linger"]); err != nil {
 		return nil, err
 	}
@@ -194,6 +446,9 @@ func ProducerConfigFromFile(filename string) (*ProducerConfig, error) {
 	if err := setIntConfig(&producerConfig.MaxRequestSize, c["max.request.size"]); err != nil {
 		return nil, err
 	}
+	if err := setIntConfig(&producerConfig.MaxRecordSize, c["max.record.size"]); err != nil {
+		return nil, err
+	}
 	setBoolConfig(&producerConfig.BlockOnBufferFull, c["block.on.buffer.full"])
 	if err := setIntConfig(&producerConfig.Retries, c["retries"]); err != nil {
 		return nil, err
@@ -201,10 +456,19 @@ func ProducerConfigFromFile(filename string) (*ProducerConfig, error) {
 	if err := setDurationConfig(&producerConfig.RetryBackoff, c["retry.backoff"]); err != nil {
 		return nil, err
 	}
+	if err := setDurationConfig(&producerConfig.RetryBackoffMax, c["retry.backoff.max"]); err != nil {
+		return nil, err
+	}
 	setStringConfig(&producerConfig.CompressionType, c["compression.type"])
 	if err := setIntConfig(&producerConfig.MaxRequests, c["max.requests"]); err != nil {
 		return nil, err
 	}
+	if err := setIntConfig(&producerConfig.MetadataChannelBuffer, c["metadata.channel.buffer"]); err != nil {
+		return nil, err
+	}
+	if err := setIntConfig(&producerConfig.MaxOutstandingRequests, c["max.outstanding.requests"]); err != nil {
+		return nil, err
+	}
 
 	setStringsConfig(&producerConfig.BrokerList, c["bootstrap.servers"])
 	if len(producerConfig.BrokerList) == 0 {
@@ -215,65 +479,313 @@ func ProducerConfigFromFile(filename string) (*ProducerConfig, error) {
 }
 
 func (kp *KafkaProducer) Send(record *ProducerRecord) <-chan *RecordMetadata {
-	record.metadataChan = make(chan *RecordMetadata, 1)
+	kp.initMetadataChan(record)
 	kp.send(record)
 	return record.metadataChan
 }
 
+// SendCallback sends a given record and calls a given callback once it is acknowledged or failed, instead of
+// returning a channel. The callback is called on the goroutine completing the record, which is shared with other
+// records, so it must not block. Panics in the callback are logged and swallowed. The result is discarded if it is nil.
+func (kp *KafkaProducer) SendCallback(record *ProducerRecord, callback func(*RecordMetadata)) {
+	record.metadataChan = nil
+	record.callback = callback
+	kp.send(record)
+}
+
+// SendMany sends given records and returns their metadata channels in the same order.
+// Metadata is looked up once per topic and all records are handed to the accumulator at once,
+// which is cheaper than calling Send for each of them.
+func (kp *KafkaProducer) SendMany(records []*ProducerRecord) []<-chan *RecordMetadata {
+	metadataChans := make([]<-chan *RecordMetadata, len(records))
+	partitionsByTopic := make(map[string][]int32)
+	errorsByTopic := make(map[string]error)
+	accepted := make([]*ProducerRecord, 0, len(records))
+	for i, record := range records {
+		kp.initMetadataChan(record)
+		metadataChans[i] = record.metadataChan
+		record, intercepted := kp.intercept(record)
+		if !intercepted || !kp.serialize(record) {
+			continue
+		}
+
+		partitions, seen := partitionsByTopic[record.Topic]
+		if !seen {
+			partitions, errorsByTopic[record.Topic] = kp.metadata.Get(record.Topic)
+			partitionsByTopic[record.Topic] = partitions
+		}
+		if err := errorsByTopic[record.Topic]; err != nil {
+			kp.fail(record, err)
+			continue
+		}
+
+		if kp.partition(record, partitions) {
+			accepted = append(accepted, record)
+		}
+	}
+
+	if !kp.accumulator.add(accepted...) {
+		for _, record := range accepted {
+			kp.fail(record, ErrProducerClosing)
+		}
+	}
+	return metadataChans
+}
+
+func (kp *KafkaProducer) initMetadataChan(record *ProducerRecord) {
+	buffer := kp.config.MetadataChannelBuffer
+	if buffer < 1 {
+		buffer = 1
+	}
+	record.metadataChan = make(chan *RecordMetadata, buffer)
+}
+
+// SyncSend sends a given record and waits until it is acknowledged or the timeout elapses.
+// Returns the record metadata and its error if the send failed, or ErrSendTimeout if no response arrived in time.
+func (kp *KafkaProducer) SyncSend(record *ProducerRecord, timeout time.Duration) (*RecordMetadata, error) {
+	select {
+	case metadata := <-kp.Send(record):
+		if metadata.Error != ErrNoError {
+			return metadata, metadata.Error
+		}
+		return metadata, nil
+	case <-time.After(timeout):
+		return nil, ErrSendTimeout
+	}
+}
+
 func (kp *KafkaProducer) send(record *ProducerRecord) {
-	metadataChan := record.metadataChan
-	metadata := new(RecordMetadata)
+	record, intercepted := kp.intercept(record)
+	if !intercepted || !kp.serialize(record) {
+		return
+	}
 
-	serializedKey, err := kp.keySerializer(record.Key)
+	partitions, err := kp.metadata.Get(record.Topic)
 	if err != nil {
-		metadata.Error = err
-		metadataChan <- metadata
+		kp.fail(record, err)
+		return
+	}
+
+	if !kp.partition(record, partitions) {
 		return
 	}
 
+	if !kp.accumulator.add(record) {
+		kp.fail(record, ErrProducerClosing)
+	}
+}
+
+// serialize encodes and signs the key and value of a given record. Fails the record and returns false on error.
+func (kp *KafkaProducer) serialize(record *ProducerRecord) bool {
+	serializedKey, err := kp.keySerializer(record.Key)
+	if err != nil {
+		kp.fail(record, err)
+		return false
+	}
+
 	serializedValue, err := kp.valueSerializer(record.Value)
 	if err != nil {
-		metadata.Error = err
-		metadataChan <- metadata
-		return
+		kp.fail(record, err)
+		return false
+	}
+
+	if kp.config.ValueSigner != nil {
+		serializedValue, err = kp.config.ValueSigner(record.Topic, serializedValue)
+		if err != nil {
+			kp.fail(record, err)
+			return false
+		}
+	}
+
+	size := len(serializedKey) + len(serializedValue)
+	if maxSize := kp.maxRecordSize(); maxSize > 0 && size > maxSize {
+		kp.fail(record, &ErrRecordTooLarge{Size: size, MaxRecordSize: maxSize})
+		return false
 	}
 
 	record.encodedKey = serializedKey
 	record.encodedValue = serializedValue
+	kp.metrics.serialized(size)
+	return true
+}
 
-	partitions, err := kp.metadata.Get(record.Topic)
-	if err != nil {
-		metadata.Error = err
-		metadataChan <- metadata
-		return
+// maxRecordSize returns the configured MaxRecordSize, or MaxRequestSize if it is not set. 0 means unlimited.
+func (kp *KafkaProducer) maxRecordSize() int {
+	if kp.config.MaxRecordSize > 0 {
+		return kp.config.MaxRecordSize
 	}
+	return kp.config.MaxRequestSize
+}
 
-	partition, err := kp.partitioner.Partition(record, partitions)
+// partition picks one of given partitions for a record. Fails the record and returns false on error.
+func (kp *KafkaProducer) partition(record *ProducerRecord, partitions []int32) bool {
+	partition, err := kp.topicPartitioner(record.Topic).Partition(record, partitions)
 	if err != nil {
-		metadata.Error = err
-		metadataChan <- metadata
-		return
+		kp.fail(record, err)
+		return false
 	}
 	record.partition = partition
+	return true
+}
+
+// topicPartitioner returns the partitioner set for a given topic with SetPartitioner or the default one.
+func (kp *KafkaProducer) topicPartitioner(topic string) (partitioner Partitioner) {
+	partitioner = kp.partitioner
+	inReadLock(&kp.partitionersLock, func() {
+		if topicPartitioner, exists := kp.partitioners[topic]; exists {
+			partitioner = topicPartitioner
+		}
+	})
+	return partitioner
+}
+
+// batchFlushed tells the partitioner of a given topic that a batch for a given partition was sent, if it wants to know.
+func (kp *KafkaProducer) batchFlushed(topic string, partition int32) {
+	if listener, ok := kp.topicPartitioner(topic).(batchListener); ok {
+		listener.batchFlushed(topic, partition)
+	}
+}
+
+// SetPartitioner makes records sent to a given topic use a given partitioner instead of the default one.
+// Takes effect on the next Send for that topic. A nil partitioner removes the override.
+func (kp *KafkaProducer) SetPartitioner(topic string, partitioner Partitioner) {
+	inWriteLock(&kp.partitionersLock, func() {
+		if partitioner == nil {
+			delete(kp.partitioners, topic)
+			return
+		}
+		kp.partitioners[topic] = partitioner
+	})
+}
+
+// fail completes a record that could not be handed to the accumulator with a given error.
+func (kp *KafkaProducer) fail(record *ProducerRecord, err error) {
+	kp.metrics.recordError()
+	record.complete(kp, &RecordMetadata{Topic: record.Topic, Partition: record.partition, Error: err})
+}
+
+// Flush sends all accumulated records without waiting for the linger time and blocks until they are acknowledged
+// or failed. Use FlushTimeout to bound the wait and learn about failed records.
+func (kp *KafkaProducer) Flush() {
+	kp.FlushTimeout(0)
+}
+
+// FlushTimeout sends all accumulated records without waiting for the linger time and blocks until they are
+// acknowledged or failed, waiting indefinitely if the timeout is not positive. Returns ErrFlushTimeout if the records
+// are not completed in time and a *FlushError holding the metadata of failed records otherwise.
+// Records sent concurrently may be flushed and reported as well.
+func (kp *KafkaProducer) FlushTimeout(timeout time.Duration) error {
+	return kp.accumulator.awaitAll(timeout)
+}
+
+func (kp *KafkaProducer) String() string {
+	return "Kafka Producer"
+}
+
+// FlushPartition sends all records accumulated for a given topic and partition without waiting for the linger time
+// and blocks until they are acknowledged. Other partitions keep accumulating as usual.
+// Returns ErrFlushTimeout if the records are not acknowledged within a given timeout.
+func (kp *KafkaProducer) FlushPartition(topic string, partition int32, timeout time.Duration) error {
+	return kp.accumulator.awaitPartition(topic, partition, timeout)
+}
+
+// IsHealthy pings the cluster with Connector.Ping and returns true if a broker responds within ReadTimeout.
+func (kp *KafkaProducer) IsHealthy() bool {
+	return kp.connector.Ping(kp.config.ReadTimeout) == nil
+}
+
+// Topics returns the sorted names of all topics in the producer's metadata cache.
+// The cache has no negative entries, topics with failed metadata lookups are not included.
+func (kp *KafkaProducer) Topics() []string {
+	return kp.metadata.Topics()
+}
 
-	kp.accumulator.addChan <- record
+// ActiveTopics returns the sorted names of topics that have records which were accepted by Send
+// but not yet acknowledged, i.e. are either waiting in the accumulator or in flight.
+func (kp *KafkaProducer) ActiveTopics() []string {
+	return kp.pending.topics()
 }
 
-func (kp *KafkaProducer) Flush() {}
+// NetworkMetrics returns a snapshot of the network activity of the producer's NetworkClient.
+func (kp *KafkaProducer) NetworkMetrics() NetworkMetrics {
+	return kp.accumulator.networkClient.Metrics()
+}
+
+// BrokerConnectionCount returns the number of brokers this producer has open connections to.
+func (kp *KafkaProducer) BrokerConnectionCount() int {
+	return len(kp.ConnectedBrokers())
+}
 
+// ConnectedBrokers describes the brokers this producer has open connections to, in no particular order.
+// Connection pools are shared with the Connector, so connections opened for its requests are included.
+func (kp *KafkaProducer) ConnectedBrokers() []BrokerInfo {
+	return kp.accumulator.networkClient.connectedBrokers()
+}
+
+// PartitionsFor returns the partitions of a given topic with their leaders and replicas, refreshing the metadata
+// if it is missing or older than MetadataExpire. Returns an empty slice if the metadata can not be fetched.
 func (kp *KafkaProducer) PartitionsFor(topic string) []PartitionInfo {
-	return []PartitionInfo{}
+	infos, err := kp.metadata.PartitionInfo(topic)
+	if err != nil {
+		Warnf(kp, "Could not get partitions for topic %s: %s", topic, err)
+		return []PartitionInfo{}
+	}
+	return infos
+}
+
+// WarmUpMetadata fetches the metadata for given topics up front, e.g. at startup, so that the first records sent to them
+// do not wait for it. Runs up to SendRoutines metadata requests at once and returns a *WarmUpError if some topics fail.
+func (kp *KafkaProducer) WarmUpMetadata(topics []string) error {
+	return kp.metadata.WarmUp(topics, kp.config.SendRoutines)
 }
 
+// Metrics returns a snapshot of the counters maintained by this producer keyed by dotted metric name,
+// e.g. producer.records-sent-total. producer.record-error-rate counts failed records per second since the producer
+// was created or its metrics were reset, producer.record-error-ratio is the share of failed records among all completed ones.
 func (kp *KafkaProducer) Metrics() map[string]Metric {
-	return make(map[string]Metric)
+	tags := make(map[string]string, len(kp.metricTags))
+	for key, value := range kp.metricTags {
+		tags[key] = value
+	}
+	return kp.metrics.snapshot(tags)
+}
+
+// ResetMetrics zeroes all counters and latency samples of this producer: the ones returned by Metrics, NetworkMetrics
+// and the batch counts of PerPartitionStats. Gauges such as active connections or pending records are left intact.
+// Counters are not reset all at once, so an operation completing concurrently may be lost or counted partially.
+// This is intended for observability, e.g. to measure a test run, not for accounting.
+func (kp *KafkaProducer) ResetMetrics() {
+	kp.metrics.reset()
+	kp.accumulator.networkClient.resetMetrics()
+	kp.accumulator.ResetStats()
+}
+
+// BackpressureSignal returns a channel that is closed while there is space for more records, see RecordAccumulator.BackpressureSignal.
+func (kp *KafkaProducer) BackpressureSignal() <-chan struct{} {
+	return kp.accumulator.BackpressureSignal()
 }
 
-// TODO return channel and remove timeout
+// SetWatermarkCallback registers a callback for buffer utilization crossings, see RecordAccumulator.SetWatermarkCallback.
+func (kp *KafkaProducer) SetWatermarkCallback(highWatermark float64, lowWatermark float64, fn func(level string)) {
+	kp.accumulator.SetWatermarkCallback(highWatermark, lowWatermark, fn)
+}
+
+// Close drains the accumulated records and waits for them to be acknowledged for up to a given timeout,
+// see CloseTimeout. Failing to drain everything in time is only logged.
 func (kp *KafkaProducer) Close(timeout time.Duration) {
-	closed := kp.accumulator.close()
-	select {
-	case <-closed:
-	case <-time.After(timeout):
+	if err := kp.CloseTimeout(timeout); err != nil {
+		log.Printf("Kafka producer closed forcefully: %s", err)
 	}
 }
+
+// CloseTimeout drains the accumulated records and waits for them to be acknowledged for up to a given timeout,
+// see RecordAccumulator.GracefulClose. Returns an *ErrDrainTimeout if not everything was drained in time.
+// If the timeout is not positive the producer is closed right away without draining.
+func (kp *KafkaProducer) CloseTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		kp.accumulator.close()
+		return nil
+	}
+
+	return kp.accumulator.GracefulClose(timeout)
+}
@@ -35,7 +35,8 @@ func TestProducerSend1(t *testing.T) {
 		AckTimeoutMs:    2000,
 		Linger:          1 * time.Second,
 	}
-	producer := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	producer, err := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
 	recordMetadata := producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
 
 	select {
@@ -64,7 +65,8 @@ func TestProducerSend1000(t *testing.T) {
 		AckTimeoutMs:    2000,
 		Linger:          1 * time.Second,
 	}
-	producer := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	producer, err := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
 	metadatas := make([]<-chan *RecordMetadata, 1000)
 	for i := 0; i < 1000; i++ {
 		metadatas[i] = producer.Send(&ProducerRecord{Topic: "siesta", Value: fmt.Sprintf("%d", i)})
@@ -97,7 +99,8 @@ func TestProducerRequiredAcks0(t *testing.T) {
 		RequiredAcks:    0,
 		Linger:          1 * time.Second,
 	}
-	producer := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	producer, err := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
 
 	metadatas := make([]<-chan *RecordMetadata, 1000)
 	for i := 0; i < 1000; i++ {
@@ -132,7 +135,8 @@ func TestProducerFlushTimeout(t *testing.T) {
 		RequiredAcks:    0,
 		Linger:          500 * time.Millisecond,
 	}
-	producer := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	producer, err := NewKafkaProducer(producerConfig, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
 	metadatas := make([]<-chan *RecordMetadata, 100)
 	for i := 0; i < 100; i++ {
 		metadatas[i] = producer.Send(&ProducerRecord{Topic: "siesta", Value: fmt.Sprintf("%d", i)})
@@ -152,3 +156,269 @@ func TestProducerFlushTimeout(t *testing.T) {
 
 	producer.Close(1 * time.Second)
 }
+
+func TestProducerSyncSendOffline(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	// linger is a minute so nothing is sent in time
+	metadata, err := producer.SyncSend(&ProducerRecord{Topic: "siesta", Value: "hello world"}, 50*time.Millisecond)
+	assert(t, err, ErrSendTimeout)
+	assert(t, metadata, (*RecordMetadata)(nil))
+
+	metadata, err = producer.SyncSend(&ProducerRecord{Topic: "unknown", Value: "hello world"}, time.Second)
+	assertNot(t, err, nil)
+	assert(t, metadata.Error, err)
+}
+
+func TestProducerTopicsAndActiveTopics(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta1": 1, "siesta2": 1}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	config.Linger = time.Minute
+	producer, err := NewKafkaProducer(config, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+	assert(t, producer.Topics(), []string{})
+	assert(t, producer.ActiveTopics(), []string{})
+
+	producer.Send(&ProducerRecord{Topic: "siesta2", Value: "hello world"})
+	producer.Send(&ProducerRecord{Topic: "siesta1", Value: "hello world"})
+	<-producer.Send(&ProducerRecord{Topic: "unknown", Value: "hello world"})
+	assert(t, producer.Topics(), []string{"siesta1", "siesta2"})
+	assert(t, producer.ActiveTopics(), []string{"siesta1", "siesta2"})
+
+	assertFatal(t, producer.FlushPartition("siesta1", 0, time.Second), nil)
+	assert(t, producer.ActiveTopics(), []string{"siesta2"})
+	assert(t, producer.Topics(), []string{"siesta1", "siesta2"})
+}
+
+func TestNewProducerConfigOptions(t *testing.T) {
+	reporter := NewNoopReporter()
+	config := NewProducerConfig(
+		WithBrokers("localhost:9092", "localhost:9093"),
+		WithClientID("test"),
+		WithBatchSize(10),
+		WithCompression("gzip"),
+		WithLingerMs(50),
+		WithRequiredAcks(-1),
+		WithMaxRequestSize(1024),
+		WithMaxRecordSize(512),
+		WithRequestTimeouts(map[int16]time.Duration{0: time.Minute}),
+		WithMetricsReporter(reporter),
+		WithBatchSize(20),
+	)
+	assert(t, config.BrokerList, []string{"localhost:9092", "localhost:9093"})
+	assert(t, config.ClientID, "test")
+	assert(t, config.BatchSize, 20)
+	assert(t, config.CompressionType, "gzip")
+	assert(t, config.Linger, 50*time.Millisecond)
+	assert(t, config.RequiredAcks, -1)
+	assert(t, config.MaxRequestSize, 1024)
+	assert(t, config.MaxRecordSize, 512)
+	assert(t, config.RequestTimeouts, map[int16]time.Duration{0: time.Minute})
+	assert(t, config.MetricsReporter, reporter)
+
+	// untouched settings keep their defaults
+	assert(t, config.MaxOutstandingRequests, NewProducerConfig().MaxOutstandingRequests)
+}
+
+func TestProducerRequestTimeouts(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: newTestBrokerLink(true)}
+	timeouts := map[int16]time.Duration{new(ProduceRequest).Key(): time.Minute}
+	producer, err := NewKafkaProducer(NewProducerConfig(WithRequestTimeouts(timeouts)), ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+
+	selector := producer.accumulator.networkClient.selector
+	assert(t, selector.config.RequestTimeouts, timeouts)
+	assert(t, selector.readTimeout(new(ProduceRequest)), time.Minute)
+}
+
+func TestProducerTraceID(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	traceID := NewTraceID()
+	assertNot(t, traceID, [16]byte{})
+	assertNot(t, traceID, NewTraceID())
+
+	metadata := <-producer.Send(&ProducerRecord{Topic: "unknown", Value: "hello world", TraceID: traceID})
+	assert(t, metadata.TraceID, traceID)
+
+	record := &ProducerRecord{Topic: "siesta", Value: "hello world", TraceID: traceID}
+	metadataChan := producer.Send(record)
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	metadata = <-metadataChan
+	assert(t, metadata.Error, ErrNoError)
+	assert(t, metadata.TraceID, traceID)
+}
+
+func TestProducerSendMany(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 2}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	config.Linger = time.Minute
+	producer, err := NewKafkaProducer(config, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+
+	records := []*ProducerRecord{
+		{Topic: "siesta", Value: "hello"},
+		{Topic: "unknown", Value: "hello"},
+		{Topic: "siesta", Value: 1},
+		{Topic: "siesta", Value: "world"},
+		{Topic: "unknown", Value: "world"},
+	}
+	metadatas := producer.SendMany(records)
+	assertFatal(t, len(metadatas), len(records))
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	assertFatal(t, producer.FlushPartition("siesta", 1, time.Second), nil)
+
+	for i, metadataChan := range metadatas {
+		metadata := <-metadataChan
+		assert(t, metadata.Topic, records[i].Topic)
+		if i == 0 || i == 3 {
+			assert(t, metadata.Error, ErrNoError)
+		} else {
+			assertNot(t, metadata.Error, ErrNoError)
+		}
+	}
+	// metadata is requested once per topic
+	assert(t, connector.requests, 2)
+}
+
+func TestProducerSendCallback(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	results := make(chan *RecordMetadata, 2)
+	callback := func(metadata *RecordMetadata) {
+		results <- metadata
+		panic("callback panics must not crash the producer")
+	}
+	producer.SendCallback(&ProducerRecord{Topic: "unknown", Value: "hello world"}, callback)
+	producer.SendCallback(&ProducerRecord{Topic: "siesta", Value: "hello world"}, callback)
+	producer.SendCallback(&ProducerRecord{Topic: "siesta", Value: "hello world"}, nil)
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+
+	assertNot(t, (<-results).Error, ErrNoError)
+	assert(t, (<-results).Error, ErrNoError)
+	metadata, err := producer.SyncSend(&ProducerRecord{Topic: "unknown", Value: "hello world"}, time.Second)
+	assertNot(t, err, nil)
+	assertNot(t, metadata, nil)
+}
+
+type testFixedPartitioner int32
+
+func (tp testFixedPartitioner) Partition(record *ProducerRecord, partitions []int32) (int32, error) {
+	return int32(tp), nil
+}
+
+func TestProducerSetPartitioner(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 3)
+	defer producer.Close(time.Second)
+
+	producer.SetPartitioner("siesta", testFixedPartitioner(2))
+	for i := 0; i < 5; i++ {
+		metadataChan := producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+		assertFatal(t, producer.FlushPartition("siesta", 2, time.Second), nil)
+		assert(t, (<-metadataChan).Partition, int32(2))
+	}
+
+	producer.SetPartitioner("siesta", nil)
+	assert(t, len(producer.partitioners), 0)
+}
+
+func TestProducerConfigValidate(t *testing.T) {
+	assert(t, NewProducerConfig().Validate(), nil)
+
+	err := new(ProducerConfig).Validate()
+	assertFatal(t, err != nil, true)
+	problems := err.(*ErrInvalidConfig).Problems
+	assert(t, problems[0], "BatchSize cannot be less than 1.")
+	assert(t, len(problems), 6)
+
+	config := NewProducerConfig()
+	config.RequiredAcks = -2
+	config.MaxOutstandingRequests = -1
+	config.CompressionType = "lz4"
+	config.MaxRecordSize = config.MaxRequestSize + 1
+	_, err = NewKafkaProducer(config, ByteSerializer, StringSerializer, &testMetadataConnector{})
+	assert(t, err, error(&ErrInvalidConfig{Problems: []string{
+		"MaxRecordSize cannot be greater than MaxRequestSize.",
+		"RequiredAcks cannot be less than -1.",
+		"MaxOutstandingRequests cannot be less than 0.",
+		"CompressionType must be one of none, gzip or snappy.",
+	}}))
+}
+
+func TestProducerMaxRecordSize(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig(WithMaxRequestSize(20))
+	config.RequiredAcks = 0
+	producer, err := NewKafkaProducer(config, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+
+	// falls back to MaxRequestSize
+	metadata := <-producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte("key"), Value: "a value of 19 bytes"})
+	assert(t, metadata.Error, error(&ErrRecordTooLarge{Size: 22, MaxRecordSize: 20}))
+
+	producer.config.MaxRecordSize = 10
+	metadata = <-producer.Send(&ProducerRecord{Topic: "siesta", Value: "eleven byte"})
+	assert(t, metadata.Error, error(&ErrRecordTooLarge{Size: 11, MaxRecordSize: 10}))
+	metadata = <-producer.Send(&ProducerRecord{Topic: "siesta", Value: "ten bytes!"})
+	assert(t, metadata.Error, ErrNoError)
+}
+
+func TestProducerCloseTimeout(t *testing.T) {
+	link := newTestBrokerLink(false)
+	producer := testOfflineProducer(link, 1, 1)
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+
+	assert(t, producer.CloseTimeout(100*time.Millisecond), error(&ErrDrainTimeout{DrainedPartitions: 0, RemainingPartitions: 1}))
+	close(link.released)
+
+	producer = testOfflineProducer(newTestBrokerLink(true), 1, 1)
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+	assert(t, producer.CloseTimeout(time.Second), nil)
+}
+
+func TestProducerPartitionsFor(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 1, 2)
+	defer producer.Close(time.Second)
+
+	infos := producer.PartitionsFor("siesta")
+	assertFatal(t, len(infos), 2)
+	assert(t, infos[1], PartitionInfo{Topic: "siesta", Partition: 1, Leader: 1, Replicas: []int32{1, 2}, InSyncReplicas: []int32{1}})
+	assert(t, producer.PartitionsFor("missing"), []PartitionInfo{})
+}
+
+func TestProducerFlushTimeoutFailures(t *testing.T) {
+	link := newTestBrokerLink(false)
+	producer := testOfflineProducer(link, 1, 2)
+	defer producer.Close(time.Second)
+	assert(t, producer.FlushTimeout(time.Second), nil)
+
+	producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte("a"), Value: "hello"})
+	producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte("b"), Value: "world"})
+	assert(t, producer.FlushTimeout(100*time.Millisecond), ErrFlushTimeout)
+	close(link.released)
+
+	// records are only sent once flushed, as the linger time is a minute
+	producer = testOfflineProducer(newTestBrokerLink(true), 1, 2)
+	defer producer.Close(time.Second)
+	producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte("a"), Value: "hello"})
+	producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte("b"), Value: "world"})
+	err := producer.FlushTimeout(time.Second)
+	flushErr, ok := err.(*FlushError)
+	assertFatal(t, ok, true)
+	assertFatal(t, len(flushErr.Failed), 2)
+	assert(t, flushErr.Failed[0].Error.Error(), "connection refused")
+	assert(t, len(producer.ActiveTopics()), 0)
+
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "again"})
+	producer.Flush()
+	assert(t, len(producer.ActiveTopics()), 0)
+}
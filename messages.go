@@ -176,7 +176,7 @@ func (md *Message) Read(decoder Decoder) *DecodingError {
 	return nil
 }
 
-//TODO compress and write if needed
+// Write encodes this message as is, compressed message sets are wrapped into a single message by compressMessages beforehand.
 func (md *Message) Write(encoder Encoder) {
 	encoder.Reserve(&CrcSlice{})
 	encoder.WriteInt8(md.MagicByte)
@@ -195,6 +195,14 @@ type MessageAndMetadata struct {
 	Value     []byte
 }
 
+// messageOverhead approximates the memory taken by a MessageAndMetadata besides its key and value.
+const messageOverhead = 64
+
+// Size returns the approximate memory footprint of this message in bytes, useful to apply back-pressure when consuming.
+func (mm *MessageAndMetadata) Size() int {
+	return len(mm.Key) + len(mm.Value) + messageOverhead
+}
+
 var (
 	reasonInvalidMessageAndOffsetOffset = "Invalid offset in MessageAndOffset"
 	reasonInvalidMessageLength          = "Invalid Message length"
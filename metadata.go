@@ -2,27 +2,60 @@ package siesta
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 type Metadata struct {
 	connector      Connector
 	metadataExpire time.Duration
-	cache          map[string]*metadataEntry
-	refreshLock    sync.Mutex
+
+	// cache points to a map[string]*metadataEntry which is replaced as a whole on refresh and never modified
+	// afterwards, so that reads need no lock. cacheLock only serializes writers.
+	cache       unsafe.Pointer
+	cacheLock   sync.Mutex
+	refreshLock sync.Mutex
 }
 
 func NetMetadata(connector Connector, metadataExpire time.Duration) *Metadata {
+	cache := make(map[string]*metadataEntry)
 	return &Metadata{
 		connector:      connector,
 		metadataExpire: metadataExpire,
-		cache:          make(map[string]*metadataEntry),
+		cache:          unsafe.Pointer(&cache),
 	}
 }
 
 func (tmc *Metadata) Get(topic string) ([]int32, error) {
-	cache := tmc.cache[topic]
+	cache, err := tmc.fresh(topic)
+	if err != nil {
+		return nil, err
+	}
+	return cache.partitions, nil
+}
+
+// PartitionInfo returns the leader and replicas of every partition of a given topic, refreshing the cache if the topic
+// is missing or expired. The returned slice is a copy and may be modified.
+func (tmc *Metadata) PartitionInfo(topic string) ([]PartitionInfo, error) {
+	cache, err := tmc.fresh(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]PartitionInfo, len(cache.infos))
+	for i, info := range cache.infos {
+		infos[i] = info
+		infos[i].Replicas = append([]int32(nil), info.Replicas...)
+		infos[i].InSyncReplicas = append([]int32(nil), info.InSyncReplicas...)
+	}
+	return infos, nil
+}
+
+func (tmc *Metadata) fresh(topic string) (*metadataEntry, error) {
+	cache := tmc.entry(topic)
 	if cache == nil || cache.timestamp.Add(tmc.metadataExpire).Before(time.Now()) {
 		err := tmc.Refresh([]string{topic})
 		if err != nil {
@@ -30,9 +63,9 @@ func (tmc *Metadata) Get(topic string) ([]int32, error) {
 		}
 	}
 
-	cache = tmc.cache[topic]
+	cache = tmc.entry(topic)
 	if cache != nil {
-		return cache.partitions, nil
+		return cache, nil
 	}
 
 	return nil, fmt.Errorf("Could not get topic metadata for topic %s", topic)
@@ -42,24 +75,141 @@ func (tmc *Metadata) Refresh(topics []string) error {
 	tmc.refreshLock.Lock()
 	defer tmc.refreshLock.Unlock()
 
+	return tmc.refresh(topics)
+}
+
+// ConcurrentRefresh refreshes metadata for several groups of topics at once, issuing one metadata request per group
+// in parallel and merging the results into the cache. Groups that succeed are cached even if others fail, in which
+// case the first encountered error is returned.
+// Map keys only label the groups, e.g. by the broker the caller expects to lead them. Each request goes through
+// Connector.GetTopicMetadata, which picks any known broker, so requests are not routed to a specific broker.
+func (tmc *Metadata) ConcurrentRefresh(topicGroups map[string][]string) error {
+	tmc.refreshLock.Lock()
+	defer tmc.refreshLock.Unlock()
+
+	results := make(chan error, len(topicGroups))
+	for _, topics := range topicGroups {
+		go func(topics []string) {
+			results <- tmc.refresh(topics)
+		}(topics)
+	}
+
+	var err error
+	for i := 0; i < len(topicGroups); i++ {
+		if groupErr := <-results; groupErr != nil && err == nil {
+			err = groupErr
+		}
+	}
+
+	return err
+}
+
+// WarmUp refreshes the metadata for given topics with one request per topic, running at most a given number of requests
+// at once, so that the first sends to them do not wait for it. Topics that succeed are cached even if others fail,
+// in which case a *WarmUpError with every failed topic is returned.
+func (tmc *Metadata) WarmUp(topics []string, routines int) error {
+	tmc.refreshLock.Lock()
+	defer tmc.refreshLock.Unlock()
+
+	if routines <= 0 || routines > len(topics) {
+		routines = len(topics)
+	}
+	work := make(chan string, len(topics))
+	for _, topic := range topics {
+		work <- topic
+	}
+	close(work)
+
+	failed := make(map[string]error)
+	var failedLock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(routines)
+	for i := 0; i < routines; i++ {
+		go func() {
+			defer wg.Done()
+			for topic := range work {
+				if err := tmc.refresh([]string{topic}); err != nil {
+					inLock(&failedLock, func() {
+						failed[topic] = err
+					})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return &WarmUpError{Failed: failed}
+	}
+	return nil
+}
+
+func (tmc *Metadata) refresh(topics []string) error {
 	topicMetadataResponse, err := tmc.connector.GetTopicMetadata(topics)
 	if err != nil {
 		return err
 	}
 
+	entries := make(map[string]*metadataEntry)
 	for _, topicMetadata := range topicMetadataResponse.TopicsMetadata {
 		partitions := make([]int32, 0)
+		infos := make([]PartitionInfo, 0)
 		for _, partitionMetadata := range topicMetadata.PartitionsMetadata {
 			partitions = append(partitions, partitionMetadata.PartitionID)
+			infos = append(infos, PartitionInfo{
+				Topic:          topicMetadata.Topic,
+				Partition:      partitionMetadata.PartitionID,
+				Leader:         partitionMetadata.Leader,
+				Replicas:       partitionMetadata.Replicas,
+				InSyncReplicas: partitionMetadata.ISR,
+			})
 		}
-		tmc.cache[topicMetadata.Topic] = newMetadataEntry(partitions)
+		entry := newMetadataEntry(partitions)
+		entry.infos = infos
+		entries[topicMetadata.Topic] = entry
 	}
 
+	tmc.store(entries)
 	return nil
 }
 
+// store replaces the cache with a copy that includes given entries.
+func (tmc *Metadata) store(entries map[string]*metadataEntry) {
+	inLock(&tmc.cacheLock, func() {
+		current := tmc.entries()
+		cache := make(map[string]*metadataEntry, len(current)+len(entries))
+		for topic, entry := range current {
+			cache[topic] = entry
+		}
+		for topic, entry := range entries {
+			cache[topic] = entry
+		}
+		atomic.StorePointer(&tmc.cache, unsafe.Pointer(&cache))
+	})
+}
+
+// Topics returns the sorted names of all topics in the cache, including expired ones.
+func (tmc *Metadata) Topics() []string {
+	topics := make([]string, 0)
+	for topic := range tmc.entries() {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (tmc *Metadata) entry(topic string) *metadataEntry {
+	return tmc.entries()[topic]
+}
+
+// entries returns the current cache, which must not be modified.
+func (tmc *Metadata) entries() map[string]*metadataEntry {
+	return *(*map[string]*metadataEntry)(atomic.LoadPointer(&tmc.cache))
+}
+
 type metadataEntry struct {
 	partitions []int32
+	infos      []PartitionInfo
 	timestamp  time.Time
 }
 
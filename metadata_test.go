@@ -0,0 +1,283 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMetadataConnector answers GetTopicMetadata with a fixed number of partitions per known topic
+// and returns a given link as the leader for every partition.
+type testMetadataConnector struct {
+	Connector
+	lock       sync.Mutex
+	partitions map[string]int32
+	requests   int
+	link       BrokerLink
+}
+
+func (tc *testMetadataConnector) GetLeader(topic string, partition int32) (BrokerLink, error) {
+	if tc.link == nil {
+		return nil, errors.New("no leader")
+	}
+	return tc.link, nil
+}
+
+// testBrokerLink fails every connection attempt once released. Until then GetConnection blocks.
+type testBrokerLink struct {
+	released chan bool
+}
+
+func newTestBrokerLink(released bool) *testBrokerLink {
+	link := &testBrokerLink{released: make(chan bool)}
+	if released {
+		close(link.released)
+	}
+	return link
+}
+
+func (tl *testBrokerLink) Failed()                          {}
+func (tl *testBrokerLink) Succeeded()                       {}
+func (tl *testBrokerLink) ReturnConnection(conn net.Conn)   {}
+func (tl *testBrokerLink) DiscardConnection(conn net.Conn)  {}
+func (tl *testBrokerLink) ConnectionStats() ConnectionStats { return ConnectionStats{} }
+func (tl *testBrokerLink) ResetConnectionStats()            {}
+func (tl *testBrokerLink) GetConnection() (int32, net.Conn, error) {
+	<-tl.released
+	return 0, nil, errors.New("connection refused")
+}
+
+func (tc *testMetadataConnector) GetTopicMetadata(topics []string) (*MetadataResponse, error) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	tc.requests++
+
+	response := new(MetadataResponse)
+	for _, topic := range topics {
+		count, exists := tc.partitions[topic]
+		if !exists {
+			return nil, errors.New("unknown topic " + topic)
+		}
+
+		topicMetadata := &TopicMetadata{Error: ErrNoError, Topic: topic}
+		for i := int32(0); i < count; i++ {
+			topicMetadata.PartitionsMetadata = append(topicMetadata.PartitionsMetadata, &PartitionMetadata{
+				Error:       ErrNoError,
+				PartitionID: i,
+				Leader:      i,
+				Replicas:    []int32{i, i + 1},
+				ISR:         []int32{i},
+			})
+		}
+		response.TopicsMetadata = append(response.TopicsMetadata, topicMetadata)
+	}
+
+	return response, nil
+}
+
+// ListOffsets answers every known partition with its partition number as offset.
+func (tc *testMetadataConnector) ListOffsets(requestInfo map[string][]*PartitionOffsetRequestInfo) (*OffsetResponse, error) {
+	response := &OffsetResponse{PartitionErrorAndOffsets: make(map[string]map[int32]*PartitionOffsetsResponse)}
+	for topic, infos := range requestInfo {
+		response.PartitionErrorAndOffsets[topic] = make(map[int32]*PartitionOffsetsResponse)
+		for _, info := range infos {
+			offsets := &PartitionOffsetsResponse{Error: ErrUnknownTopicOrPartition}
+			if info.Partition < tc.partitions[topic] {
+				offsets = &PartitionOffsetsResponse{Error: ErrNoError, Offsets: []int64{int64(info.Partition)}}
+			}
+			response.PartitionErrorAndOffsets[topic][info.Partition] = offsets
+		}
+	}
+	return response, nil
+}
+
+// OffsetFetch answers every known partition with its partition number as committed offset.
+func (tc *testMetadataConnector) OffsetFetch(group string, topics map[string][]int32) (*OffsetFetchResponse, error) {
+	response := &OffsetFetchResponse{Offsets: make(map[string]map[int32]*OffsetMetadataAndError)}
+	for topic, partitions := range topics {
+		response.Offsets[topic] = make(map[int32]*OffsetMetadataAndError)
+		for _, partition := range partitions {
+			offset := &OffsetMetadataAndError{Offset: InvalidOffset, Error: ErrUnknownTopicOrPartition}
+			if partition < tc.partitions[topic] {
+				offset = &OffsetMetadataAndError{Offset: int64(partition), Error: ErrNoError}
+			}
+			response.Offsets[topic][partition] = offset
+		}
+	}
+	return response, nil
+}
+
+func TestMetadataConcurrentRefresh(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"a": 1, "b": 2, "c": 3}}
+	metadata := NetMetadata(connector, time.Minute)
+
+	err := metadata.ConcurrentRefresh(map[string][]string{
+		"first":  {"a", "b"},
+		"second": {"c"},
+	})
+	assertFatal(t, err, nil)
+	assert(t, connector.requests, 2)
+
+	topics := make([]string, 0)
+	for topic := range metadata.entries() {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	assert(t, topics, []string{"a", "b", "c"})
+
+	partitions, err := metadata.Get("c")
+	assert(t, err, nil)
+	assert(t, partitions, []int32{0, 1, 2})
+	assert(t, connector.requests, 2)
+}
+
+func TestMetadataConcurrentRefreshPartialFailure(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"a": 1}}
+	metadata := NetMetadata(connector, time.Minute)
+
+	err := metadata.ConcurrentRefresh(map[string][]string{
+		"good": {"a"},
+		"bad":  {"missing"},
+	})
+	assertNot(t, err, nil)
+
+	partitions, err := metadata.Get("a")
+	assert(t, err, nil)
+	assert(t, partitions, []int32{0})
+}
+
+func TestMetadataWarmUp(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"a": 1, "b": 2}}
+	metadata := NetMetadata(connector, time.Minute)
+
+	err := metadata.WarmUp([]string{"a", "b", "missing"}, 2)
+	warmUpErr, ok := err.(*WarmUpError)
+	assertFatal(t, ok, true)
+	assert(t, len(warmUpErr.Failed), 1)
+	assertNot(t, warmUpErr.Failed["missing"], nil)
+	assert(t, metadata.Topics(), []string{"a", "b"})
+	assert(t, connector.requests, 3)
+
+	partitions, err := metadata.Get("b")
+	assert(t, err, nil)
+	assert(t, partitions, []int32{0, 1})
+	assert(t, connector.requests, 3)
+
+	assert(t, metadata.WarmUp([]string{"a"}, 0), nil)
+	assert(t, connector.requests, 4)
+}
+
+func TestMetadataPartitionInfo(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"a": 2}}
+	metadata := NetMetadata(connector, time.Minute)
+
+	infos, err := metadata.PartitionInfo("a")
+	assertFatal(t, err, nil)
+	assert(t, infos, []PartitionInfo{
+		{Topic: "a", Partition: 0, Leader: 0, Replicas: []int32{0, 1}, InSyncReplicas: []int32{0}},
+		{Topic: "a", Partition: 1, Leader: 1, Replicas: []int32{1, 2}, InSyncReplicas: []int32{1}},
+	})
+
+	infos[0].Replicas[0] = 5
+	infos, err = metadata.PartitionInfo("a")
+	assertFatal(t, err, nil)
+	assert(t, infos[0].Replicas, []int32{0, 1})
+	assert(t, connector.requests, 1)
+
+	_, err = metadata.PartitionInfo("missing")
+	assertNot(t, err, nil)
+}
+
+// lockedMetadataCache is the read-write locked map Metadata used before it switched to copy-on-write,
+// kept as a baseline for benchmarks.
+type lockedMetadataCache struct {
+	cache map[string]*metadataEntry
+	lock  sync.RWMutex
+}
+
+func (lc *lockedMetadataCache) entry(topic string) (entry *metadataEntry) {
+	inReadLock(&lc.lock, func() {
+		entry = lc.cache[topic]
+	})
+	return entry
+}
+
+func (lc *lockedMetadataCache) store(topic string, entry *metadataEntry) {
+	inWriteLock(&lc.lock, func() {
+		lc.cache[topic] = entry
+	})
+}
+
+// benchmarkMetadataReads runs b.N lookups of a cached topic spread over 100 goroutines while another goroutine
+// keeps refreshing the topic.
+func benchmarkMetadataReads(b *testing.B, read func(), refresh func()) {
+	const producers = 100
+	stop := make(chan bool)
+	refreshed := make(chan bool)
+	go func() {
+		defer close(refreshed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				refresh()
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for i := 0; i < producers; i++ {
+		go func(reads int) {
+			defer wg.Done()
+			for j := 0; j < reads; j++ {
+				read()
+			}
+		}(b.N/producers + 1)
+	}
+	wg.Wait()
+	b.StopTimer()
+	close(stop)
+	<-refreshed
+}
+
+func BenchmarkMetadataEntryLocked(b *testing.B) {
+	partitions := []int32{0, 1, 2}
+	cache := &lockedMetadataCache{cache: map[string]*metadataEntry{"siesta": newMetadataEntry(partitions)}}
+	benchmarkMetadataReads(b, func() {
+		cache.entry("siesta")
+	}, func() {
+		cache.store("siesta", newMetadataEntry(partitions))
+	})
+}
+
+func BenchmarkMetadataEntry(b *testing.B) {
+	partitions := []int32{0, 1, 2}
+	metadata := NetMetadata(nil, time.Minute)
+	metadata.store(map[string]*metadataEntry{"siesta": newMetadataEntry(partitions)})
+	benchmarkMetadataReads(b, func() {
+		metadata.entry("siesta")
+	}, func() {
+		metadata.store(map[string]*metadataEntry{"siesta": newMetadataEntry(partitions)})
+	})
+}
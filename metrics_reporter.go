@@ -0,0 +1,101 @@
+package siesta
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// MetricsReporter receives metric updates as they happen and forwards them to a metrics backend.
+// Implementations must be safe for concurrent use.
+type MetricsReporter interface {
+	// Count adds a given delta to a counter.
+	Count(name string, delta int64, tags map[string]string)
+
+	// Gauge sets a gauge to a given value.
+	Gauge(name string, value float64, tags map[string]string)
+
+	// Histogram records a single observation.
+	Histogram(name string, value float64, tags map[string]string)
+}
+
+type noopReporter struct{}
+
+// NewNoopReporter returns a MetricsReporter that discards everything.
+func NewNoopReporter() MetricsReporter {
+	return noopReporter{}
+}
+
+func (noopReporter) Count(name string, delta int64, tags map[string]string)       {}
+func (noopReporter) Gauge(name string, value float64, tags map[string]string)     {}
+func (noopReporter) Histogram(name string, value float64, tags map[string]string) {}
+
+// DatadogReporter is a MetricsReporter that sends metrics to a DogStatsD agent over UDP.
+type DatadogReporter struct {
+	conn net.Conn
+}
+
+// NewDatadogReporter creates a new DatadogReporter that sends metrics to a DogStatsD agent at a given address, e.g. localhost:8125.
+func NewDatadogReporter(addr string) (*DatadogReporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DatadogReporter{conn: conn}, nil
+}
+
+// Count adds a given delta to a counter.
+func (dr *DatadogReporter) Count(name string, delta int64, tags map[string]string) {
+	dr.send(fmt.Sprintf("%s:%d|c", name, delta), tags)
+}
+
+// Gauge sets a gauge to a given value.
+func (dr *DatadogReporter) Gauge(name string, value float64, tags map[string]string) {
+	dr.send(fmt.Sprintf("%s:%g|g", name, value), tags)
+}
+
+// Histogram records a single observation.
+func (dr *DatadogReporter) Histogram(name string, value float64, tags map[string]string) {
+	dr.send(fmt.Sprintf("%s:%g|h", name, value), tags)
+}
+
+// Close closes the underlying UDP connection.
+func (dr *DatadogReporter) Close() error {
+	return dr.conn.Close()
+}
+
+func (dr *DatadogReporter) send(metric string, tags map[string]string) {
+	if _, err := dr.conn.Write([]byte(metric + datadogTags(tags))); err != nil {
+		Debugf(dr, "Could not send metric %s: %s", metric, err)
+	}
+}
+
+func (dr *DatadogReporter) String() string {
+	return "Datadog Reporter"
+}
+
+func datadogTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buffer bytes.Buffer
+	buffer.WriteString("|#")
+	for i, key := range keys {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(key)
+		buffer.WriteString(":")
+		buffer.WriteString(tags[key])
+	}
+	return buffer.String()
+}
@@ -0,0 +1,98 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingReporter struct {
+	lock       sync.Mutex
+	counts     map[string]int64
+	histograms map[string][]float64
+}
+
+func newRecordingReporter() *recordingReporter {
+	return &recordingReporter{
+		counts:     make(map[string]int64),
+		histograms: make(map[string][]float64),
+	}
+}
+
+func (rr *recordingReporter) Count(name string, delta int64, tags map[string]string) {
+	inLock(&rr.lock, func() {
+		rr.counts[name] += delta
+	})
+}
+
+func (rr *recordingReporter) Gauge(name string, value float64, tags map[string]string) {}
+
+func (rr *recordingReporter) Histogram(name string, value float64, tags map[string]string) {
+	inLock(&rr.lock, func() {
+		rr.histograms[name] = append(rr.histograms[name], value)
+	})
+}
+
+func TestProducerMetricsReporter(t *testing.T) {
+	reporter := newRecordingReporter()
+	metrics := newProducerMetrics(reporter, map[string]string{"client-id": "siesta"})
+
+	metrics.serialized(10)
+	metrics.batchSent(10)
+	metrics.recordSent()
+	metrics.recordSent()
+	metrics.recordError()
+	metrics.requestCompleted(10 * time.Millisecond)
+
+	assert(t, reporter.counts["producer.records-sent-total"], int64(2))
+	assert(t, reporter.counts["producer.record-errors-total"], int64(1))
+	assert(t, reporter.counts["producer.serialized-bytes-total"], int64(10))
+	assert(t, reporter.counts["producer.bytes-sent-total"], int64(10))
+	assert(t, reporter.counts["producer.batches-sent-total"], int64(1))
+	assert(t, reporter.counts["producer.requests-sent-total"], int64(1))
+	assert(t, reporter.histograms["producer.request-latency"], []float64{10.0})
+}
+
+func TestDatadogReporter(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	checkErr(t, err)
+	defer listener.Close()
+
+	reporter, err := NewDatadogReporter(listener.LocalAddr().String())
+	checkErr(t, err)
+	defer reporter.Close()
+
+	tags := map[string]string{"topic": "siesta", "client-id": "siesta"}
+	expected := []string{
+		"producer.records-sent-total:1|c|#client-id:siesta,topic:siesta",
+		"producer.buffer-size:0.5|g|#client-id:siesta,topic:siesta",
+		"producer.request-latency:12.5|h",
+	}
+	reporter.Count("producer.records-sent-total", 1, tags)
+	reporter.Gauge("producer.buffer-size", 0.5, tags)
+	reporter.Histogram("producer.request-latency", 12.5, nil)
+
+	buffer := make([]byte, 512)
+	for _, metric := range expected {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		checkErr(t, err)
+		assert(t, string(buffer[:n]), metric)
+	}
+}
@@ -0,0 +1,240 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MultiConnector is a Connector that routes requests to one of several Connectors, usually for different clusters,
+// by the longest matching topic name prefix. The route with an empty prefix matches every topic and thus is the default.
+type MultiConnector struct {
+	routes     map[string]Connector
+	connectors []Connector
+}
+
+// NewMultiConnector creates a MultiConnector for given routes from topic name prefixes to Connectors.
+// A Connector may be used for several prefixes.
+func NewMultiConnector(routes map[string]Connector) *MultiConnector {
+	connectors := make([]Connector, 0, len(routes))
+	seen := make(map[Connector]bool)
+	for _, connector := range routes {
+		if !seen[connector] {
+			seen[connector] = true
+			connectors = append(connectors, connector)
+		}
+	}
+
+	return &MultiConnector{
+		routes:     routes,
+		connectors: connectors,
+	}
+}
+
+// route returns the Connector for the longest prefix matching a given topic.
+func (mc *MultiConnector) route(topic string) (Connector, error) {
+	var connector Connector
+	longest := -1
+	for prefix, candidate := range mc.routes {
+		if strings.HasPrefix(topic, prefix) && len(prefix) > longest {
+			connector = candidate
+			longest = len(prefix)
+		}
+	}
+
+	if connector == nil {
+		return nil, ErrNoConnectorForTopic
+	}
+	return connector, nil
+}
+
+// GetTopicMetadata asks each Connector for the topics routed to it and merges the responses.
+// Passing it an empty topic list retrieves metadata for all topics of all clusters. Broker IDs are only unique within
+// a cluster, so the merged broker list may contain brokers of different clusters with the same ID.
+func (mc *MultiConnector) GetTopicMetadata(topics []string) (*MetadataResponse, error) {
+	requests := make(map[Connector][]string)
+	if len(topics) == 0 {
+		for _, connector := range mc.connectors {
+			requests[connector] = topics
+		}
+	}
+	for _, topic := range topics {
+		connector, err := mc.route(topic)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get topic metadata for %s: %s", topic, err)
+		}
+		requests[connector] = append(requests[connector], topic)
+	}
+
+	merged := &MetadataResponse{Brokers: make([]*Broker, 0), TopicsMetadata: make([]*TopicMetadata, 0)}
+	for connector, connectorTopics := range requests {
+		response, err := connector.GetTopicMetadata(connectorTopics)
+		if err != nil {
+			return nil, err
+		}
+
+		merged.Brokers = append(merged.Brokers, response.Brokers...)
+		for _, topicMetadata := range response.TopicsMetadata {
+			// a Connector for a prefix also returns topics of other prefixes when asked for all topics
+			if owner, err := mc.route(topicMetadata.Topic); err == nil && owner == connector {
+				merged.TopicsMetadata = append(merged.TopicsMetadata, topicMetadata)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// GetAvailableOffset issues an offset request through the Connector routed for a given topic.
+func (mc *MultiConnector) GetAvailableOffset(topic string, partition int32, offsetTime int64) (int64, error) {
+	connector, err := mc.route(topic)
+	if err != nil {
+		return InvalidOffset, err
+	}
+	return connector.GetAvailableOffset(topic, partition, offsetTime)
+}
+
+// ListOffsets issues offset requests through the Connectors routed for given topics and merges their responses.
+func (mc *MultiConnector) ListOffsets(requestInfo map[string][]*PartitionOffsetRequestInfo) (*OffsetResponse, error) {
+	requests := make(map[Connector]map[string][]*PartitionOffsetRequestInfo)
+	for topic, infos := range requestInfo {
+		connector, err := mc.route(topic)
+		if err != nil {
+			return nil, err
+		}
+		if requests[connector] == nil {
+			requests[connector] = make(map[string][]*PartitionOffsetRequestInfo)
+		}
+		requests[connector][topic] = infos
+	}
+
+	merged := &OffsetResponse{PartitionErrorAndOffsets: make(map[string]map[int32]*PartitionOffsetsResponse)}
+	for connector, request := range requests {
+		response, err := connector.ListOffsets(request)
+		if err != nil {
+			return nil, err
+		}
+		for topic, offsets := range response.PartitionErrorAndOffsets {
+			merged.PartitionErrorAndOffsets[topic] = offsets
+		}
+	}
+	return merged, nil
+}
+
+// Fetch issues a fetch request through the Connector routed for a given topic.
+func (mc *MultiConnector) Fetch(topic string, partition int32, offset int64) (*FetchResponse, error) {
+	connector, err := mc.route(topic)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Fetch(topic, partition, offset)
+}
+
+// GetOffset gets the offset for a given group from the cluster of the Connector routed for a given topic.
+func (mc *MultiConnector) GetOffset(group string, topic string, partition int32) (int64, error) {
+	connector, err := mc.route(topic)
+	if err != nil {
+		return InvalidOffset, err
+	}
+	return connector.GetOffset(group, topic, partition)
+}
+
+// OffsetFetch gets the committed offsets for a given group from the clusters of the Connectors routed for given topics
+// and merges their responses.
+func (mc *MultiConnector) OffsetFetch(group string, topics map[string][]int32) (*OffsetFetchResponse, error) {
+	requests := make(map[Connector]map[string][]int32)
+	for topic, partitions := range topics {
+		connector, err := mc.route(topic)
+		if err != nil {
+			return nil, err
+		}
+		if requests[connector] == nil {
+			requests[connector] = make(map[string][]int32)
+		}
+		requests[connector][topic] = partitions
+	}
+
+	merged := &OffsetFetchResponse{Offsets: make(map[string]map[int32]*OffsetMetadataAndError)}
+	for connector, request := range requests {
+		response, err := connector.OffsetFetch(group, request)
+		if err != nil {
+			return nil, err
+		}
+		for topic, offsets := range response.Offsets {
+			merged.Offsets[topic] = offsets
+		}
+	}
+	return merged, nil
+}
+
+// CommitOffset commits the offset for a given group to the cluster of the Connector routed for a given topic.
+func (mc *MultiConnector) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	connector, err := mc.route(topic)
+	if err != nil {
+		return err
+	}
+	return connector.CommitOffset(group, topic, partition, offset)
+}
+
+// GetLeader returns the leader of a given topic and partition in the cluster of the Connector routed for the topic,
+// which makes produce requests go to that cluster.
+func (mc *MultiConnector) GetLeader(topic string, partition int32) (BrokerLink, error) {
+	connector, err := mc.route(topic)
+	if err != nil {
+		return nil, err
+	}
+	return connector.GetLeader(topic, partition)
+}
+
+// Ping pings every Connector one after another, each with a given timeout, and returns the first error.
+func (mc *MultiConnector) Ping(timeout time.Duration) error {
+	for _, connector := range mc.connectors {
+		if err := connector.Ping(timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all Connectors. The returned channel gets a single value once all of them are closed.
+func (mc *MultiConnector) Close() <-chan bool {
+	closed := make(chan bool, 1)
+	go func() {
+		for _, connector := range mc.connectors {
+			<-connector.Close()
+		}
+		closed <- true
+	}()
+	return closed
+}
+
+// WithRequestInterceptor adds a given request interceptor to all Connectors.
+func (mc *MultiConnector) WithRequestInterceptor(fn func(apiKey int16, body []byte) []byte) Connector {
+	for _, connector := range mc.connectors {
+		connector.WithRequestInterceptor(fn)
+	}
+	return mc
+}
+
+// WithResponseInterceptor adds a given response interceptor to all Connectors.
+func (mc *MultiConnector) WithResponseInterceptor(fn func(apiKey int16, body []byte) []byte) Connector {
+	for _, connector := range mc.connectors {
+		connector.WithResponseInterceptor(fn)
+	}
+	return mc
+}
@@ -0,0 +1,99 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultiConnectorRouting(t *testing.T) {
+	usersLink := newTestBrokerLink(true)
+	defaultLink := newTestBrokerLink(true)
+	users := &testMetadataConnector{partitions: map[string]int32{"user-events": 2, "user-events-eu": 1}, link: usersLink}
+	metrics := &testMetadataConnector{partitions: map[string]int32{"metrics": 3}, link: defaultLink}
+	connector := NewMultiConnector(map[string]Connector{
+		"user-events": users,
+		"":            metrics,
+	})
+
+	leader, err := connector.GetLeader("user-events-eu", 0)
+	checkErr(t, err)
+	assert(t, leader, BrokerLink(usersLink))
+	leader, err = connector.GetLeader("metrics", 0)
+	checkErr(t, err)
+	assert(t, leader, BrokerLink(defaultLink))
+
+	response, err := connector.GetTopicMetadata([]string{"metrics", "user-events", "user-events-eu"})
+	checkErr(t, err)
+	assert(t, len(response.TopicsMetadata), 3)
+	assert(t, users.requests, 1)
+	assert(t, metrics.requests, 1)
+
+	_, err = connector.GetTopicMetadata([]string{"unknown"})
+	assertNot(t, err, nil)
+
+	offsets, err := connector.ListOffsets(map[string][]*PartitionOffsetRequestInfo{
+		"metrics":     {{Partition: 2, Time: LatestTime, MaxNumOffsets: 1}},
+		"user-events": {{Partition: 1, Time: LatestTime, MaxNumOffsets: 1}, {Partition: 2, Time: LatestTime, MaxNumOffsets: 1}},
+	})
+	checkErr(t, err)
+	assert(t, offsets.PartitionErrorAndOffsets["metrics"][2].Offsets, []int64{2})
+	assert(t, offsets.PartitionErrorAndOffsets["user-events"][1].Offsets, []int64{1})
+	assert(t, offsets.PartitionErrorAndOffsets["user-events"][2].Error, ErrUnknownTopicOrPartition)
+
+	committed, err := connector.OffsetFetch("group", map[string][]int32{"metrics": {1}, "user-events-eu": {0}})
+	checkErr(t, err)
+	assert(t, len(committed.Offsets), 2)
+	assert(t, committed.Offsets["metrics"][1].Offset, int64(1))
+	assert(t, committed.Offsets["user-events-eu"][0].Error, ErrNoError)
+}
+
+func TestMultiConnectorNoRoute(t *testing.T) {
+	connector := NewMultiConnector(map[string]Connector{
+		"user-events": &testMetadataConnector{},
+	})
+
+	_, err := connector.GetLeader("metrics", 0)
+	assert(t, err, ErrNoConnectorForTopic)
+	offset, err := connector.GetOffset("group", "metrics", 0)
+	assert(t, err, ErrNoConnectorForTopic)
+	assert(t, offset, InvalidOffset)
+	_, err = connector.GetTopicMetadata([]string{"metrics"})
+	assertNot(t, err, nil)
+	_, err = connector.ListOffsets(map[string][]*PartitionOffsetRequestInfo{"metrics": {{Partition: 0, Time: LatestTime, MaxNumOffsets: 1}}})
+	assert(t, err, ErrNoConnectorForTopic)
+	_, err = connector.OffsetFetch("group", map[string][]int32{"metrics": {0}})
+	assert(t, err, ErrNoConnectorForTopic)
+}
+
+func TestMultiConnectorProducer(t *testing.T) {
+	users := &testMetadataConnector{partitions: map[string]int32{"user-events": 1}, link: newTestBrokerLink(true)}
+	connector := NewMultiConnector(map[string]Connector{"user": users})
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	config.Linger = time.Minute
+	producer, err := NewKafkaProducer(config, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+
+	metadataChan := producer.Send(&ProducerRecord{Topic: "user-events", Value: "hello world"})
+	assertFatal(t, producer.FlushPartition("user-events", 0, time.Second), nil)
+	assert(t, (<-metadataChan).Error, ErrNoError)
+
+	metadata := <-producer.Send(&ProducerRecord{Topic: "metrics", Value: "hello world"})
+	assertNot(t, metadata.Error, ErrNoError)
+}
@@ -1,6 +1,14 @@
 package siesta
 
-import "net"
+import (
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type NetworkClient struct {
 	connector               Connector
@@ -13,12 +21,52 @@ type NetworkClient struct {
 	metadataFetchInProgress bool
 	lastNoNodeAvailableMs   int64
 	selector                *Selector
-	connections             map[string]*net.TCPConn
+	connections             map[string]net.Conn
 	requiredAcks            int
 	ackTimeoutMs            int32
+	compression             CompressionCodec
+	retries                 int
+	retryBackoff            time.Duration
+	retryBackoffMax         time.Duration
+	retryFilter             func(error) bool
+	maxRequestSize          int
+	queued                  map[BrokerLink][]*partitionBatch
+	queuedLock              sync.Mutex
+	metrics                 *producerMetrics
+	pending                 *pendingRecords
+	outstanding             *outstandingRequests
+	brokers                 map[BrokerLink]bool
+	brokersLock             sync.Mutex
+	closed                  bool
+	closedLock              sync.RWMutex
+	stopIdleCheck           chan struct{}
+}
+
+// NetworkMetrics describes the network activity of a NetworkClient.
+// Connection statistics cover the brokers this client sent requests to. Connection pools are shared with the Connector,
+// so they include connections established for its requests too.
+type NetworkMetrics struct {
+	ConnectionAttempts  int64
+	ConnectionFailures  int64
+	ActiveConnections   int32
+	BytesSent           int64
+	BytesReceived       int64
+	WriteErrors         int64
+	ReadErrors          int64
+	ConnectLatencyP50Ms float64
+	ConnectLatencyP99Ms float64
 }
 
 type NetworkClientConfig struct {
+	// IdleConnectionTimeout is the time after which connections to brokers this client sent requests to are closed
+	// if no request used them. The next request to such a broker establishes a new connection. 0 keeps idle connections open.
+	IdleConnectionTimeout time.Duration
+
+	// RequestTimeouts overrides the read timeout for requests with a given Kafka API key, see SelectorConfig.
+	RequestTimeouts map[int16]time.Duration
+
+	metrics *producerMetrics
+	pending *pendingRecords
 }
 
 func NewNetworkClient(config NetworkClientConfig, connector Connector, producerConfig *ProducerConfig) *NetworkClient {
@@ -26,73 +74,543 @@ func NewNetworkClient(config NetworkClientConfig, connector Connector, producerC
 	client.connector = connector
 	client.requiredAcks = producerConfig.RequiredAcks
 	client.ackTimeoutMs = producerConfig.AckTimeoutMs
+	client.compression, _ = compressionCodecFor(producerConfig.CompressionType)
+	client.retries = producerConfig.Retries
+	client.retryBackoff = producerConfig.RetryBackoff
+	client.retryBackoffMax = producerConfig.RetryBackoffMax
+	client.retryFilter = producerConfig.RetryFilter
+	client.maxRequestSize = producerConfig.MaxRequestSize
+	client.queued = make(map[BrokerLink][]*partitionBatch)
+	client.metrics = config.metrics
+	if client.metrics == nil {
+		client.metrics = newProducerMetrics(nil, nil)
+	}
+	client.pending = config.pending
+	if client.pending == nil {
+		client.pending = newPendingRecords()
+	}
+	client.outstanding = newOutstandingRequests(producerConfig.MaxOutstandingRequests)
 	selectorConfig := NewSelectorConfig(producerConfig)
+	selectorConfig.RequestTimeouts = config.RequestTimeouts
 	client.selector = NewSelector(selectorConfig)
-	client.connections = make(map[string]*net.TCPConn, 0)
+	client.connections = make(map[string]net.Conn, 0)
+	client.brokers = make(map[BrokerLink]bool)
+	client.stopIdleCheck = make(chan struct{})
+	if config.IdleConnectionTimeout > 0 {
+		go client.closeIdleConnections(config.IdleConnectionTimeout)
+	}
 	return client
 }
 
 func (nc *NetworkClient) send(topic string, partition int32, batch []*ProducerRecord) {
+	nc.sendAttempt(topic, partition, batch, 0)
+}
+
+// partitionBatch is a batch of records for a single partition that waits to be packed into a produce request.
+type partitionBatch struct {
+	topic     string
+	partition int32
+	batch     []*ProducerRecord
+	attempt   int
+	messages  []*Message
+	bytes     int
+	size      int
+}
+
+// messageOverheadV0 is the size of a v0 message in a message set besides its key and value.
+const messageOverheadV0 = 26
+
+// sendAttempt sends a given batch, counting the times it was already retried.
+func (nc *NetworkClient) sendAttempt(topic string, partition int32, batch []*ProducerRecord, attempt int) {
 	leader, err := nc.connector.GetLeader(topic, partition)
 	if err != nil {
-		for _, record := range batch {
-			record.metadataChan <- &RecordMetadata{Error: err}
-		}
+		nc.retryOrFail(topic, partition, batch, attempt, err)
+		return
 	}
+	nc.addBroker(leader)
 
-	request := new(ProduceRequest)
-	request.RequiredAcks = int16(nc.requiredAcks)
-	request.AckTimeoutMs = nc.ackTimeoutMs
-	for _, record := range batch {
-		request.AddMessage(record.Topic, record.partition, &Message{Key: record.encodedKey, Value: record.encodedValue})
+	pb := &partitionBatch{topic: topic, partition: partition, batch: batch, attempt: attempt}
+	messages := make([]*Message, len(batch))
+	for i, record := range batch {
+		messages[i] = &Message{Key: record.encodedKey, Value: record.encodedValue}
+		pb.bytes += len(record.encodedKey) + len(record.encodedValue)
+	}
+	pb.messages = messages
+	if nc.compression != CompressionNone {
+		// the broker assigns consecutive offsets to the wrapped messages, so the response base offset still belongs to the first record
+		wrapper, err := compressMessages(nc.compression, messages)
+		if err != nil {
+			nc.fail(batch, err)
+			return
+		}
+		pb.messages = []*Message{wrapper}
+	}
+	for _, message := range pb.messages {
+		pb.size += messageOverheadV0 + len(message.Key) + len(message.Value)
 	}
-	responseChan := nc.selector.Send(leader, request)
 
-	if nc.requiredAcks > 0 {
-		go listenForResponse(topic, partition, batch, responseChan)
-	} else {
+	if nc.requiredAcks == 0 {
+		request := nc.produceRequest([]*partitionBatch{pb})
+		if _, sent := nc.sendRequest(leader, request); !sent {
+			nc.fail(batch, ErrProducerClosing)
+			return
+		}
+		nc.metrics.batchSent(pb.bytes)
 		// acks = 0 case, just complete all requests
 		for _, record := range batch {
-			record.metadataChan <- &RecordMetadata{
+			nc.complete(record, &RecordMetadata{
 				Offset:    -1,
 				Topic:     topic,
 				Partition: partition,
 				Error:     ErrNoError,
+			})
+		}
+		return
+	}
+
+	// every queued batch schedules a request, which takes along all batches queued for the broker by then
+	inLock(&nc.queuedLock, func() {
+		nc.queued[leader] = append(nc.queued[leader], pb)
+	})
+	nc.outstanding.run(leader, func() {
+		nc.sendQueued(leader)
+	})
+}
+
+// dequeue takes batches queued for a given broker for a single produce request. The first batch is always taken,
+// later ones as long as the request stays within MaxRequestSize and each partition occurs only once.
+func (nc *NetworkClient) dequeue(leader BrokerLink) []*partitionBatch {
+	var taken []*partitionBatch
+	inLock(&nc.queuedLock, func() {
+		remaining := make([]*partitionBatch, 0)
+		partitions := make(map[TopicPartition]bool)
+		size := 0
+		for _, pb := range nc.queued[leader] {
+			tp := TopicPartition{Topic: pb.topic, Partition: pb.partition}
+			fits := nc.maxRequestSize <= 0 || size+pb.size <= nc.maxRequestSize
+			if len(taken) == 0 || (fits && !partitions[tp]) {
+				taken = append(taken, pb)
+				size += pb.size
+			} else {
+				remaining = append(remaining, pb)
 			}
+			partitions[tp] = true
+		}
+		if len(remaining) == 0 {
+			delete(nc.queued, leader)
+		} else {
+			nc.queued[leader] = remaining
+		}
+	})
+	return taken
+}
+
+// sendQueued sends the batches queued for a given broker in a single produce request. Releases the outstanding request
+// slot right away if an earlier request already took all of them.
+func (nc *NetworkClient) sendQueued(leader BrokerLink) {
+	batches := nc.dequeue(leader)
+	if len(batches) == 0 {
+		nc.outstanding.release(leader)
+		return
+	}
+
+	sentAt := time.Now()
+	responseChan, sent := nc.sendRequest(leader, nc.produceRequest(batches))
+	if !sent {
+		nc.outstanding.release(leader)
+		for _, pb := range batches {
+			nc.fail(pb.batch, ErrProducerClosing)
+		}
+		return
+	}
+	for _, pb := range batches {
+		nc.metrics.batchSent(pb.bytes)
+	}
+	go nc.listenForResponse(leader, batches, sentAt, responseChan)
+}
+
+func (nc *NetworkClient) produceRequest(batches []*partitionBatch) *ProduceRequest {
+	request := new(ProduceRequest)
+	request.RequiredAcks = int16(nc.requiredAcks)
+	request.AckTimeoutMs = nc.ackTimeoutMs
+	for _, pb := range batches {
+		for _, message := range pb.messages {
+			request.AddMessage(pb.topic, pb.partition, message)
 		}
 	}
+	return request
 }
 
-func listenForResponse(topic string, partition int32, batch []*ProducerRecord, responseChan <-chan *rawResponseAndError) {
+func (nc *NetworkClient) listenForResponse(leader BrokerLink, batches []*partitionBatch, sentAt time.Time, responseChan <-chan *rawResponseAndError) {
 	response := <-responseChan
+	nc.outstanding.release(leader)
+	nc.metrics.requestCompleted(time.Since(sentAt))
 	if response.err != nil {
-		for _, record := range batch {
-			record.metadataChan <- &RecordMetadata{Error: response.err}
+		for _, pb := range batches {
+			nc.retryOrFail(pb.topic, pb.partition, pb.batch, pb.attempt, response.err)
 		}
+		return
 	}
 
 	decoder := NewBinaryDecoder(response.bytes)
 	produceResponse := new(ProduceResponse)
 	decodingErr := produceResponse.Read(decoder)
 	if decodingErr != nil {
-		for _, record := range batch {
-			record.metadataChan <- &RecordMetadata{Error: decodingErr.err}
+		for _, pb := range batches {
+			nc.fail(pb.batch, decodingErr.Error())
+		}
+		return
+	}
+
+	for _, pb := range batches {
+		status := produceResponse.Status[pb.topic][pb.partition]
+		if status == nil {
+			nc.fail(pb.batch, ErrUnknown)
+			continue
+		}
+		if retriableProduceErrors[status.Error] {
+			nc.retryOrFail(pb.topic, pb.partition, pb.batch, pb.attempt, status.Error)
+			continue
+		}
+		currentOffset := status.Offset
+		for _, record := range pb.batch {
+			nc.complete(record, &RecordMetadata{
+				Topic:     pb.topic,
+				Partition: pb.partition,
+				Offset:    currentOffset,
+				Error:     status.Error,
+			})
+			currentOffset++
+		}
+	}
+}
+
+// leaderRemover is implemented by Connectors that cache partition leaders, so that a stale leader can be dropped.
+type leaderRemover interface {
+	removeLeader(topic string, partition int32)
+}
+
+// retryOrFail sends a batch that failed with a retriable error again after a backoff, or fails it with that error once
+// it was retried Retries times, the client is closed or the configured RetryFilter rejects the error. The cached leader of the partition is dropped before retrying,
+// so that the batch goes to the current leader.
+func (nc *NetworkClient) retryOrFail(topic string, partition int32, batch []*ProducerRecord, attempt int, err error) {
+	closed := false
+	inReadLock(&nc.closedLock, func() {
+		closed = nc.closed
+	})
+	if closed || attempt >= nc.retries || (nc.retryFilter != nil && !nc.retryFilter(err)) {
+		nc.fail(batch, err)
+		return
+	}
+
+	if remover, ok := nc.connector.(leaderRemover); ok {
+		remover.removeLeader(topic, partition)
+	}
+	time.AfterFunc(nc.backoff(attempt), func() {
+		nc.sendAttempt(topic, partition, batch, attempt+1)
+	})
+}
+
+// backoff returns a random delay between 0 and RetryBackoff doubled for every previous attempt, capped at RetryBackoffMax if set.
+func (nc *NetworkClient) backoff(attempt int) time.Duration {
+	if nc.retryBackoff <= 0 {
+		return 0
+	}
+
+	ceiling := nc.retryBackoff
+	for i := 0; i < attempt && ceiling < math.MaxInt64/2; i++ {
+		ceiling *= 2
+	}
+	if nc.retryBackoffMax > 0 && ceiling > nc.retryBackoffMax {
+		ceiling = nc.retryBackoffMax
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func (nc *NetworkClient) sendMessageSet(topic string, partition int32, messageSet []byte) {
+	leader, err := nc.connector.GetLeader(topic, partition)
+	if err != nil {
+		Warnf(nc, "Could not send raw batch to %s:%d: %s", topic, partition, err)
+		return
+	}
+	nc.addBroker(leader)
+
+	request := new(ProduceRequest)
+	request.RequiredAcks = int16(nc.requiredAcks)
+	request.AckTimeoutMs = nc.ackTimeoutMs
+	request.AddMessageSet(topic, partition, messageSet)
+	if nc.requiredAcks == 0 {
+		if _, sent := nc.sendRequest(leader, request); !sent {
+			Warnf(nc, "Could not send raw batch to %s:%d: %s", topic, partition, ErrProducerClosing)
+			return
 		}
+		nc.metrics.batchSent(len(messageSet))
+		return
 	}
 
-	status := produceResponse.Status[topic][partition]
-	currentOffset := status.Offset
+	nc.outstanding.run(leader, func() {
+		sentAt := time.Now()
+		responseChan, sent := nc.sendRequest(leader, request)
+		if !sent {
+			nc.outstanding.release(leader)
+			Warnf(nc, "Could not send raw batch to %s:%d: %s", topic, partition, ErrProducerClosing)
+			return
+		}
+		nc.metrics.batchSent(len(messageSet))
+		go func() {
+			response := <-responseChan
+			nc.outstanding.release(leader)
+			nc.metrics.requestCompleted(time.Since(sentAt))
+			if response.err != nil {
+				Warnf(nc, "Could not send raw batch to %s:%d: %s", topic, partition, response.err)
+				return
+			}
+
+			produceResponse := new(ProduceResponse)
+			if decodingErr := produceResponse.Read(NewBinaryDecoder(response.bytes)); decodingErr != nil {
+				Warnf(nc, "Could not decode produce response for raw batch to %s:%d: %s", topic, partition, decodingErr.Reason())
+				return
+			}
+
+			if status := produceResponse.Status[topic][partition]; status != nil && status.Error != ErrNoError {
+				Warnf(nc, "Raw batch to %s:%d failed: %s", topic, partition, status.Error)
+			}
+		}()
+	})
+}
+
+// sendRequest hands a given request to the selector unless this client is closed.
+// Requests parked behind slow brokers may be sent after the client was closed, these are reported as not sent.
+func (nc *NetworkClient) sendRequest(leader BrokerLink, request Request) (responseChan <-chan *rawResponseAndError, sent bool) {
+	inReadLock(&nc.closedLock, func() {
+		if nc.closed {
+			return
+		}
+		responseChan = nc.selector.Send(leader, request)
+		sent = true
+	})
+	return responseChan, sent
+}
+
+func (nc *NetworkClient) fail(batch []*ProducerRecord, err error) {
 	for _, record := range batch {
-		record.metadataChan <- &RecordMetadata{
-			Topic:     topic,
-			Partition: partition,
-			Offset:    currentOffset,
-			Error:     status.Error,
+		nc.complete(record, &RecordMetadata{Topic: record.Topic, Partition: record.partition, Error: err})
+	}
+}
+
+func (nc *NetworkClient) complete(record *ProducerRecord, metadata *RecordMetadata) {
+	if !nc.pending.claim(record) {
+		return
+	}
+	if metadata.Error == ErrNoError {
+		nc.metrics.recordSent()
+	} else {
+		nc.metrics.recordError()
+	}
+	if metadata.Error != ErrNoError {
+		nc.pending.fail(metadata)
+	}
+	record.complete(nc, metadata)
+	nc.pending.done(record.Topic, record.partition)
+}
+
+// Metrics returns a snapshot of the network activity of this client.
+func (nc *NetworkClient) Metrics() NetworkMetrics {
+	selectorMetrics := nc.selector.metrics
+	metrics := NetworkMetrics{
+		BytesSent:     atomic.LoadInt64(&selectorMetrics.bytesSent),
+		BytesReceived: atomic.LoadInt64(&selectorMetrics.bytesReceived),
+		WriteErrors:   atomic.LoadInt64(&selectorMetrics.writeErrors),
+		ReadErrors:    atomic.LoadInt64(&selectorMetrics.readErrors),
+	}
+
+	var latencies []time.Duration
+	inLock(&nc.brokersLock, func() {
+		for broker := range nc.brokers {
+			stats := broker.ConnectionStats()
+			metrics.ConnectionAttempts += stats.Attempts
+			metrics.ConnectionFailures += stats.Failures
+			metrics.ActiveConnections += stats.Active
+			latencies = append(latencies, stats.ConnectLatencies...)
+		}
+	})
+	metrics.ConnectLatencyP50Ms = latencyPercentileMs(latencies, 0.5)
+	metrics.ConnectLatencyP99Ms = latencyPercentileMs(latencies, 0.99)
+
+	return metrics
+}
+
+// BrokerInfo describes a broker a NetworkClient has open connections to.
+type BrokerInfo struct {
+	// Broker is the ID and address of the broker, nil if its BrokerLink does not tell.
+	Broker *Broker
+
+	// ActiveConnections is the number of open connections to the broker.
+	ActiveConnections int32
+}
+
+// brokerAddressed is implemented by BrokerLinks that know which broker they connect to.
+type brokerAddressed interface {
+	Broker() *Broker
+}
+
+// idleConnectionCloser is implemented by BrokerLinks that can close connections nobody used for a while.
+type idleConnectionCloser interface {
+	CloseIdleConnections(timeout time.Duration) int
+}
+
+// closeIdleConnections periodically closes connections to the brokers this client sent requests to that stayed idle
+// for a given timeout, until the client is closed. Connections are therefore closed after at most 1.5 times the timeout.
+func (nc *NetworkClient) closeIdleConnections(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-nc.stopIdleCheck:
+			return
+		case <-ticker.C:
+			var links []BrokerLink
+			inLock(&nc.brokersLock, func() {
+				for link := range nc.brokers {
+					links = append(links, link)
+				}
+			})
+			for _, link := range links {
+				if closer, ok := link.(idleConnectionCloser); ok {
+					closer.CloseIdleConnections(timeout)
+				}
+			}
+		}
+	}
+}
+
+// connectedBrokers returns the brokers this client sent requests to that currently have open connections.
+// Never opens a connection.
+func (nc *NetworkClient) connectedBrokers() []BrokerInfo {
+	brokers := make([]BrokerInfo, 0)
+	inLock(&nc.brokersLock, func() {
+		for link := range nc.brokers {
+			active := link.ConnectionStats().Active
+			if active == 0 {
+				continue
+			}
+			info := BrokerInfo{ActiveConnections: active}
+			if addressed, ok := link.(brokerAddressed); ok {
+				info.Broker = addressed.Broker()
+			}
+			brokers = append(brokers, info)
 		}
-		currentOffset++
+	})
+	return brokers
+}
+
+// resetMetrics zeroes the byte and error counters and the connection statistics of all brokers this client talked to.
+func (nc *NetworkClient) resetMetrics() {
+	nc.selector.metrics.reset()
+	inLock(&nc.brokersLock, func() {
+		for broker := range nc.brokers {
+			broker.ResetConnectionStats()
+		}
+	})
+}
+
+func (nc *NetworkClient) addBroker(broker BrokerLink) {
+	inLock(&nc.brokersLock, func() {
+		nc.brokers[broker] = true
+	})
+}
+
+// latencyPercentileMs returns the nearest-rank percentile of given latencies in milliseconds, 0 if there are none.
+func latencyPercentileMs(latencies []time.Duration, percentile float64) float64 {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Sort(durations(sorted))
+	rank := int(math.Ceil(percentile*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
 	}
+	return float64(sorted[rank]) / float64(time.Millisecond)
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func (nc *NetworkClient) String() string {
+	return "Network Client"
 }
 
 func (nc *NetworkClient) close() {
-	nc.selector.Close()
+	inWriteLock(&nc.closedLock, func() {
+		if !nc.closed {
+			close(nc.stopIdleCheck)
+		}
+		nc.closed = true
+		nc.selector.Close()
+	})
+}
+
+// outstandingRequests limits the number of produce requests per broker that were sent but not yet acknowledged.
+// Requests over the limit are parked per broker and sent in order as earlier ones are acknowledged,
+// so a slow broker never holds up batches for other brokers.
+type outstandingRequests struct {
+	limit    int
+	lock     sync.Mutex
+	inFlight map[BrokerLink]int
+	parked   map[BrokerLink][]func()
+}
+
+func newOutstandingRequests(limit int) *outstandingRequests {
+	return &outstandingRequests{
+		limit:    limit,
+		inFlight: make(map[BrokerLink]int),
+		parked:   make(map[BrokerLink][]func()),
+	}
+}
+
+// run calls send right away if a given broker has less than limit requests in flight, otherwise parks it
+// until one of them is released. Never blocks. There is no limit if it is not positive.
+func (or *outstandingRequests) run(broker BrokerLink, send func()) {
+	if or.limit <= 0 {
+		send()
+		return
+	}
+
+	ready := false
+	inLock(&or.lock, func() {
+		if or.inFlight[broker] < or.limit {
+			or.inFlight[broker]++
+			ready = true
+			return
+		}
+		or.parked[broker] = append(or.parked[broker], send)
+	})
+	if ready {
+		send()
+	}
+}
+
+// release marks a request to a given broker as acknowledged and sends the next parked request for it, if any.
+func (or *outstandingRequests) release(broker BrokerLink) {
+	if or.limit <= 0 {
+		return
+	}
+
+	var next func()
+	inLock(&or.lock, func() {
+		if parked := or.parked[broker]; len(parked) > 0 {
+			next = parked[0]
+			or.parked[broker] = parked[1:]
+			return
+		}
+		or.inFlight[broker]--
+	})
+	if next != nil {
+		next()
+	}
 }
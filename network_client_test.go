@@ -0,0 +1,472 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNetworkClientMaxOutstandingRequests(t *testing.T) {
+	link := newTestBrokerLink(false)
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: link}
+	config := NewProducerConfig()
+	config.MaxOutstandingRequests = 1
+	client := NewNetworkClient(NetworkClientConfig{}, connector, config)
+	defer client.close()
+
+	newBatch := func() []*ProducerRecord {
+		return []*ProducerRecord{{Topic: "siesta", metadataChan: make(chan *RecordMetadata, 1)}}
+	}
+	first := newBatch()
+	second := newBatch()
+
+	sent := make(chan bool)
+	go func() {
+		client.send("siesta", 0, first)
+		client.send("siesta", 0, second)
+		sent <- true
+	}()
+
+	// sending over the limit must not block the caller
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Send blocked on a broker with too many outstanding requests")
+	}
+	inLock(&client.outstanding.lock, func() {
+		assert(t, client.outstanding.inFlight[link], 1)
+		assert(t, len(client.outstanding.parked[link]), 1)
+	})
+
+	// the first request fails, which sends the parked one
+	close(link.released)
+	for _, batch := range [][]*ProducerRecord{first, second} {
+		select {
+		case metadata := <-batch[0].metadataChan:
+			assertNot(t, metadata.Error, ErrNoError)
+		case <-time.After(time.Second):
+			t.Fatal("Request was not completed")
+		}
+	}
+}
+
+func TestOutstandingRequestsPerBroker(t *testing.T) {
+	outstanding := newOutstandingRequests(2)
+	slow := newTestBrokerLink(false)
+	fast := newTestBrokerLink(false)
+
+	var sent []string
+	send := func(name string) func() {
+		return func() {
+			sent = append(sent, name)
+		}
+	}
+
+	outstanding.run(slow, send("slow1"))
+	outstanding.run(slow, send("slow2"))
+	outstanding.run(slow, send("slow3"))
+	outstanding.run(fast, send("fast1"))
+	assert(t, sent, []string{"slow1", "slow2", "fast1"})
+
+	outstanding.release(fast)
+	outstanding.release(slow)
+	assert(t, sent, []string{"slow1", "slow2", "fast1", "slow3"})
+	assert(t, outstanding.inFlight[slow], 2)
+	assert(t, outstanding.inFlight[fast], 0)
+}
+
+func TestOutstandingRequestsUnlimited(t *testing.T) {
+	outstanding := newOutstandingRequests(0)
+	link := newTestBrokerLink(true)
+	sent := 0
+	for i := 0; i < 100; i++ {
+		outstanding.run(link, func() { sent++ })
+	}
+	outstanding.release(link)
+	assert(t, sent, 100)
+	assert(t, len(outstanding.inFlight), 0)
+}
+
+func TestNetworkClientMetrics(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	checkErr(t, err)
+	portNumber, err := strconv.Atoi(port)
+	checkErr(t, err)
+	link := newBrokerLink(&Broker{ID: 0, Host: host, Port: int32(portNumber)}, true, time.Second, 1, nil)
+
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: link}
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	client := NewNetworkClient(NetworkClientConfig{}, connector, config)
+	defer client.close()
+
+	client.send("siesta", 0, []*ProducerRecord{{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: make(chan *RecordMetadata, 1)}})
+
+	var metrics NetworkMetrics
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if metrics = client.Metrics(); metrics.BytesSent > 0 {
+			break
+		}
+	}
+	assert(t, metrics.BytesSent > 0, true)
+	assert(t, metrics.ConnectionAttempts, int64(1))
+	assert(t, metrics.ConnectionFailures, int64(0))
+	assert(t, metrics.ActiveConnections, int32(1))
+	assert(t, metrics.WriteErrors, int64(0))
+	assert(t, metrics.ReadErrors, int64(0))
+
+	assert(t, client.connectedBrokers(), []BrokerInfo{{Broker: link.broker, ActiveConnections: 1}})
+}
+
+func TestNetworkClientConnectedBrokers(t *testing.T) {
+	link := newTestBrokerLink(true)
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: link}
+	client := NewNetworkClient(NetworkClientConfig{}, connector, NewProducerConfig())
+	defer client.close()
+	assert(t, len(client.connectedBrokers()), 0)
+
+	// the test link never has open connections
+	client.addBroker(link)
+	assert(t, len(client.connectedBrokers()), 0)
+}
+
+func TestNetworkClientIdleConnectionTimeout(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	checkErr(t, err)
+	portNumber, err := strconv.Atoi(port)
+	checkErr(t, err)
+	link := newBrokerLink(&Broker{ID: 0, Host: host, Port: int32(portNumber)}, true, time.Second, 1, nil)
+
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: link}
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	client := NewNetworkClient(NetworkClientConfig{IdleConnectionTimeout: 50 * time.Millisecond}, connector, config)
+	defer client.close()
+
+	client.send("siesta", 0, []*ProducerRecord{{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: make(chan *RecordMetadata, 1)}})
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if client.Metrics().BytesSent > 0 {
+			break
+		}
+	}
+
+	var active int32 = -1
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if active = link.ConnectionStats().Active; active == 0 {
+			break
+		}
+	}
+	assert(t, active, int32(0))
+	assert(t, link.ConnectionStats().Attempts, int64(1))
+}
+
+// testProduceResponse encodes a produce response for a single topic and partition, including size and correlation id.
+func testProduceResponse(topic string, partition int32, errorCode int16, offset int64) []byte {
+	return testProduceResponseFor(topic, map[int32][2]int64{partition: {int64(errorCode), offset}})
+}
+
+// testProduceResponseFor encodes a produce response with error code and offset pairs for several partitions of a topic.
+func testProduceResponseFor(topic string, statuses map[int32][2]int64) []byte {
+	writeResponse := func(encoder Encoder) {
+		encoder.WriteInt32(1)
+		encoder.WriteString(topic)
+		encoder.WriteInt32(int32(len(statuses)))
+		for partition, status := range statuses {
+			encoder.WriteInt32(partition)
+			encoder.WriteInt16(int16(status[0]))
+			encoder.WriteInt64(status[1])
+		}
+	}
+	sizing := NewSizingEncoder()
+	writeResponse(sizing)
+
+	encoder := NewBinaryEncoder(make([]byte, sizing.Size()+8))
+	encoder.WriteInt32(sizing.Size() + 4)
+	encoder.WriteInt32(0)
+	writeResponse(encoder)
+	return encoder.buffer
+}
+
+func TestNetworkClientInterceptors(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	sizes := make(chan int, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(size))); err != nil {
+			return
+		}
+		sizes <- int(binary.BigEndian.Uint32(size))
+		conn.Write(testProduceResponse("siesta", 0, 0, 7))
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	checkErr(t, err)
+	portNumber, err := strconv.Atoi(port)
+	checkErr(t, err)
+	defaultConnector := &DefaultConnector{config: *NewConnectorConfig()}
+	link := defaultConnector.newBrokerLink(&Broker{ID: 0, Host: host, Port: int32(portNumber)})
+
+	originalSize := 0
+	responseKeys := make(chan int16, 1)
+	defaultConnector.WithRequestInterceptor(func(apiKey int16, body []byte) []byte {
+		assert(t, apiKey, new(ProduceRequest).Key())
+		originalSize = len(body)
+		return append(body, 0x00, 0x00)
+	}).WithResponseInterceptor(func(apiKey int16, body []byte) []byte {
+		responseKeys <- apiKey
+		return body
+	})
+
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: link}
+	client := NewNetworkClient(NetworkClientConfig{}, connector, NewProducerConfig())
+	defer client.close()
+
+	record := &ProducerRecord{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: make(chan *RecordMetadata, 1)}
+	client.send("siesta", 0, []*ProducerRecord{record})
+
+	metadata := <-record.metadataChan
+	assert(t, metadata.Error, ErrNoError)
+	assert(t, metadata.Offset, int64(7))
+	assert(t, <-sizes, originalSize+2)
+	assert(t, <-responseKeys, new(ProduceRequest).Key())
+}
+
+func TestNetworkClientPacksPartitions(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	requests := make(chan bool, 3)
+	respond := make(chan bool)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		responses := [][]byte{
+			testProduceResponse("siesta", 0, 0, 7),
+			testProduceResponseFor("siesta", map[int32][2]int64{1: {0, 42}, 2: {2, -1}}),
+		}
+		for _, response := range responses {
+			size := make([]byte, 4)
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(size))); err != nil {
+				return
+			}
+			requests <- true
+			<-respond
+			conn.Write(response)
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	checkErr(t, err)
+	portNumber, err := strconv.Atoi(port)
+	checkErr(t, err)
+	link := newBrokerLink(&Broker{ID: 0, Host: host, Port: int32(portNumber)}, true, time.Second, 1, nil)
+
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 3}, link: link}
+	config := NewProducerConfig()
+	config.MaxOutstandingRequests = 1
+	client := NewNetworkClient(NetworkClientConfig{}, connector, config)
+	defer client.close()
+
+	newRecords := func(n int) []*ProducerRecord {
+		records := make([]*ProducerRecord, n)
+		for i := range records {
+			records[i] = &ProducerRecord{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: make(chan *RecordMetadata, 1)}
+		}
+		return records
+	}
+	first := newRecords(1)
+	client.send("siesta", 0, first)
+	<-requests
+
+	// both batches wait for the outstanding request and then go out together
+	second := newRecords(2)
+	third := newRecords(1)
+	client.send("siesta", 1, second)
+	client.send("siesta", 2, third)
+	respond <- true
+	<-requests
+	respond <- true
+
+	metadata := <-first[0].metadataChan
+	assert(t, metadata.Error, ErrNoError)
+	assert(t, metadata.Offset, int64(7))
+	for i, record := range second {
+		metadata := <-record.metadataChan
+		assert(t, metadata.Error, ErrNoError)
+		assert(t, metadata.Partition, int32(1))
+		assert(t, metadata.Offset, int64(42+i))
+	}
+	metadata = <-third[0].metadataChan
+	assert(t, metadata.Error, ErrInvalidMessage)
+	assert(t, metadata.Partition, int32(2))
+	assert(t, len(requests), 0)
+}
+
+func TestNetworkClientRetries(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	requests := make(chan bool, 3)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		responses := [][]byte{
+			testProduceResponse("siesta", 0, 6, -1),
+			testProduceResponse("siesta", 0, 5, -1),
+			testProduceResponse("siesta", 0, 0, 42),
+		}
+		for _, response := range responses {
+			size := make([]byte, 4)
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(size))); err != nil {
+				return
+			}
+			requests <- true
+			conn.Write(response)
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	checkErr(t, err)
+	portNumber, err := strconv.Atoi(port)
+	checkErr(t, err)
+	link := newBrokerLink(&Broker{ID: 0, Host: host, Port: int32(portNumber)}, true, time.Second, 1, nil)
+
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: link}
+	config := NewProducerConfig()
+	config.Retries = 2
+	config.RetryBackoff = 10 * time.Millisecond
+	client := NewNetworkClient(NetworkClientConfig{}, connector, config)
+	defer client.close()
+
+	metadataChan := make(chan *RecordMetadata, 1)
+	client.send("siesta", 0, []*ProducerRecord{{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: metadataChan}})
+
+	select {
+	case metadata := <-metadataChan:
+		assert(t, metadata.Error, ErrNoError)
+		assert(t, metadata.Offset, int64(42))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record was not acknowledged")
+	}
+	assert(t, len(requests), 3)
+}
+
+func TestNetworkClientRetriesExhausted(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig()
+	config.Retries = 2
+	config.RetryBackoff = time.Millisecond
+	client := NewNetworkClient(NetworkClientConfig{}, connector, config)
+	defer client.close()
+
+	metadataChan := make(chan *RecordMetadata, 1)
+	client.send("siesta", 0, []*ProducerRecord{{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: metadataChan}})
+	metadata := <-metadataChan
+	assert(t, metadata.Error.Error(), "connection refused")
+}
+
+func TestNetworkClientRetryFilter(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig()
+	config.Retries = 2
+	config.RetryBackoff = time.Millisecond
+	filtered := make(chan error, 3)
+	config.RetryFilter = func(err error) bool {
+		filtered <- err
+		return false
+	}
+	client := NewNetworkClient(NetworkClientConfig{}, connector, config)
+	defer client.close()
+
+	metadataChan := make(chan *RecordMetadata, 1)
+	client.send("siesta", 0, []*ProducerRecord{{Topic: "siesta", encodedValue: []byte("hello world"), metadataChan: metadataChan}})
+	metadata := <-metadataChan
+	assert(t, metadata.Error.Error(), "connection refused")
+	assert(t, len(filtered), 1)
+	assert(t, <-filtered, metadata.Error)
+}
+
+func TestNetworkClientBackoff(t *testing.T) {
+	client := &NetworkClient{retryBackoff: 100 * time.Millisecond, retryBackoffMax: time.Second}
+	for attempt, ceiling := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			backoff := client.backoff(attempt)
+			assert(t, backoff >= 0 && backoff <= ceiling, true)
+		}
+	}
+
+	client.retryBackoffMax = 0
+	assert(t, client.backoff(100) >= 0, true)
+	client.retryBackoff = 0
+	assert(t, client.backoff(3), time.Duration(0))
+}
+
+func TestLatencyPercentileMs(t *testing.T) {
+	assert(t, latencyPercentileMs(nil, 0.5), 0.0)
+
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert(t, latencyPercentileMs(latencies, 0.5), 50.0)
+	assert(t, latencyPercentileMs(latencies, 0.99), 99.0)
+	assert(t, latencyPercentileMs(latencies[:1], 0.99), 100.0)
+}
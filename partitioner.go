@@ -4,6 +4,8 @@ import (
 	"hash"
 	"hash/fnv"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -55,3 +57,84 @@ func NewRandomPartitioner() *RandomPartitioner {
 func (rp *RandomPartitioner) Partition(record *ProducerRecord, partitions []int32) (int32, error) {
 	return rp.random.Int31n(int32(len(partitions))), nil
 }
+
+// RoundRobinPartitioner cycles through the partitions of each topic independently of record keys.
+// It is safe for concurrent use.
+type RoundRobinPartitioner struct {
+	lock     sync.Mutex
+	counters map[string]*uint32
+}
+
+func NewRoundRobinPartitioner() *RoundRobinPartitioner {
+	return &RoundRobinPartitioner{
+		counters: make(map[string]*uint32),
+	}
+}
+
+func (rrp *RoundRobinPartitioner) Partition(record *ProducerRecord, partitions []int32) (int32, error) {
+	var counter *uint32
+	inLock(&rrp.lock, func() {
+		counter = rrp.counters[record.Topic]
+		if counter == nil {
+			counter = new(uint32)
+			rrp.counters[record.Topic] = counter
+		}
+	})
+
+	next := atomic.AddUint32(counter, 1) - 1
+	return partitions[next%uint32(len(partitions))], nil
+}
+
+// batchListener is implemented by partitioners that want to know when the accumulator sends a batch.
+type batchListener interface {
+	batchFlushed(topic string, partition int32)
+}
+
+// StickyPartitioner sends records without a key to the same partition of a topic until a batch for that partition
+// is sent, either because it is full or because the linger time elapsed, and then sticks to another random partition.
+// This fills fewer, larger batches than spreading such records over all partitions. Records with a key are hashed
+// like with HashPartitioner. It is safe for concurrent use, but the next partition is only picked when the producer
+// it is used with sends a batch, so it should not be shared between producers.
+type StickyPartitioner struct {
+	lock   sync.Mutex
+	hash   *HashPartitioner
+	random *rand.Rand
+	sticky map[string]int32
+}
+
+func NewStickyPartitioner() *StickyPartitioner {
+	return &StickyPartitioner{
+		hash:   NewHashPartitioner(),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		sticky: make(map[string]int32),
+	}
+}
+
+func (sp *StickyPartitioner) Partition(record *ProducerRecord, partitions []int32) (partition int32, err error) {
+	inLock(&sp.lock, func() {
+		if record.Key != nil {
+			partition, err = sp.hash.Partition(record, partitions)
+			return
+		}
+
+		if sticky, exists := sp.sticky[record.Topic]; exists {
+			for _, candidate := range partitions {
+				if candidate == sticky {
+					partition = sticky
+					return
+				}
+			}
+		}
+		partition = partitions[sp.random.Intn(len(partitions))]
+		sp.sticky[record.Topic] = partition
+	})
+	return partition, err
+}
+
+func (sp *StickyPartitioner) batchFlushed(topic string, partition int32) {
+	inLock(&sp.lock, func() {
+		if sticky, exists := sp.sticky[topic]; exists && sticky == partition {
+			delete(sp.sticky, topic)
+		}
+	})
+}
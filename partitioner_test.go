@@ -0,0 +1,116 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoundRobinPartitioner(t *testing.T) {
+	partitioner := NewRoundRobinPartitioner()
+	partitions := []int32{3, 5, 7}
+
+	for _, expected := range []int32{3, 5, 7, 3} {
+		partition, err := partitioner.Partition(&ProducerRecord{Topic: "siesta"}, partitions)
+		checkErr(t, err)
+		assert(t, partition, expected)
+	}
+
+	// topics are cycled independently
+	partition, err := partitioner.Partition(&ProducerRecord{Topic: "other"}, partitions)
+	checkErr(t, err)
+	assert(t, partition, int32(3))
+}
+
+func TestRoundRobinPartitionerConcurrent(t *testing.T) {
+	partitioner := NewRoundRobinPartitioner()
+	partitions := []int32{0, 1, 2, 3}
+
+	var lock sync.Mutex
+	counts := make(map[int32]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				partition, _ := partitioner.Partition(&ProducerRecord{Topic: "siesta"}, partitions)
+				inLock(&lock, func() {
+					counts[partition]++
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, partition := range partitions {
+		assert(t, counts[partition], 200)
+	}
+}
+
+func TestStickyPartitioner(t *testing.T) {
+	partitioner := NewStickyPartitioner()
+	partitions := []int32{0, 1, 2, 3}
+
+	sticky, err := partitioner.Partition(&ProducerRecord{Topic: "siesta"}, partitions)
+	checkErr(t, err)
+	for i := 0; i < 10; i++ {
+		partition, err := partitioner.Partition(&ProducerRecord{Topic: "siesta"}, partitions)
+		checkErr(t, err)
+		assert(t, partition, sticky)
+	}
+
+	// a batch for another partition does not unstick
+	partitioner.batchFlushed("siesta", (sticky+1)%4)
+	partition, _ := partitioner.Partition(&ProducerRecord{Topic: "siesta"}, partitions)
+	assert(t, partition, sticky)
+
+	partitioner.batchFlushed("siesta", sticky)
+	inLock(&partitioner.lock, func() {
+		_, exists := partitioner.sticky["siesta"]
+		assert(t, exists, false)
+	})
+
+	// the sticky partition is dropped when it is no longer among the partitions
+	partition, _ = partitioner.Partition(&ProducerRecord{Topic: "siesta"}, []int32{sticky + 10})
+	assert(t, partition, sticky+10)
+}
+
+func TestStickyPartitionerProducer(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 4)
+	defer producer.Close(time.Second)
+	partitioner := NewStickyPartitioner()
+	producer.SetPartitioner("siesta", partitioner)
+
+	metadatas := make([]<-chan *RecordMetadata, 5)
+	for i := range metadatas {
+		metadatas[i] = producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	}
+	for partition := int32(0); partition < 4; partition++ {
+		assertFatal(t, producer.FlushPartition("siesta", partition, time.Second), nil)
+	}
+
+	sticky := (<-metadatas[0]).Partition
+	for _, metadata := range metadatas[1:] {
+		assert(t, (<-metadata).Partition, sticky)
+	}
+	// sending the batch unsticks the partition
+	inLock(&partitioner.lock, func() {
+		assert(t, len(partitioner.sticky), 0)
+	})
+}
@@ -20,6 +20,8 @@ type ProduceRequest struct {
 	RequiredAcks int16
 	AckTimeoutMs int32
 	Data         map[string]map[int32][]*MessageAndOffset
+
+	messageSets map[string]map[int32][]byte
 }
 
 // Key returns the Kafka API key for ProduceRequest.
@@ -35,11 +37,21 @@ func (pr *ProduceRequest) Version() int16 {
 func (pr *ProduceRequest) Write(encoder Encoder) {
 	encoder.WriteInt16(pr.RequiredAcks)
 	encoder.WriteInt32(pr.AckTimeoutMs)
-	encoder.WriteInt32(int32(len(pr.Data)))
 
-	for topic, partitionData := range pr.Data {
+	topics := make(map[string]bool)
+	for topic := range pr.Data {
+		topics[topic] = true
+	}
+	for topic := range pr.messageSets {
+		topics[topic] = true
+	}
+	encoder.WriteInt32(int32(len(topics)))
+
+	for topic := range topics {
+		partitionData := pr.Data[topic]
+		messageSets := pr.messageSets[topic]
 		encoder.WriteString(topic)
-		encoder.WriteInt32(int32(len(partitionData)))
+		encoder.WriteInt32(int32(len(partitionData) + len(messageSets)))
 
 		for partition, data := range partitionData {
 			encoder.WriteInt32(partition)
@@ -49,6 +61,11 @@ func (pr *ProduceRequest) Write(encoder Encoder) {
 			}
 			encoder.UpdateReserved()
 		}
+
+		for partition, messageSet := range messageSets {
+			encoder.WriteInt32(partition)
+			encoder.WriteBytes(messageSet)
+		}
 	}
 }
 
@@ -65,6 +82,19 @@ func (pr *ProduceRequest) AddMessage(topic string, partition int32, message *Mes
 	pr.Data[topic][partition] = append(pr.Data[topic][partition], &MessageAndOffset{Message: message})
 }
 
+// AddMessageSet is a convenience method to add an already encoded message set to be produced to a topic partition as is.
+func (pr *ProduceRequest) AddMessageSet(topic string, partition int32, messageSet []byte) {
+	if pr.messageSets == nil {
+		pr.messageSets = make(map[string]map[int32][]byte)
+	}
+
+	if pr.messageSets[topic] == nil {
+		pr.messageSets[topic] = make(map[int32][]byte)
+	}
+
+	pr.messageSets[topic][partition] = messageSet
+}
+
 // ProduceResponse contains highest assigned offsets by topic partitions and errors if they occurred.
 type ProduceResponse struct {
 	Status map[string]map[int32]*ProduceResponseStatus
@@ -38,6 +38,12 @@ func TestProduceRequest(t *testing.T) {
 	goodProduceRequest.AckTimeoutMs = 2000
 	goodProduceRequest.AddMessage("siesta", 0, &Message{Value: []byte("hello world")})
 	testRequest(t, goodProduceRequest, goodProduceRequestBytes)
+
+	messageSetProduceRequest := new(ProduceRequest)
+	messageSetProduceRequest.RequiredAcks = 1
+	messageSetProduceRequest.AckTimeoutMs = 2000
+	messageSetProduceRequest.AddMessageSet("siesta", 0, goodProduceRequestBytes[30:])
+	testRequest(t, messageSetProduceRequest, goodProduceRequestBytes)
 }
 
 func TestProduceResponse(t *testing.T) {
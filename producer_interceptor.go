@@ -0,0 +1,71 @@
+package siesta
+
+// ProducerInterceptor intercepts records before they are sent and their metadata once they are acknowledged or failed,
+// e.g. for tracing or logging. Interceptors are configured with ProducerConfig.Interceptors and run in order.
+type ProducerInterceptor interface {
+	// OnSend is called with every record before it is serialized and returns the record to send instead, which may be
+	// the given one. Returning nil or a record with an empty topic aborts the send with ErrInterceptorAborted,
+	// as does a panic, which is recovered and logged.
+	OnSend(record *ProducerRecord) *ProducerRecord
+
+	// OnAcknowledgement is called with the metadata of every intercepted record before it is handed to the caller.
+	// err is nil if the record was sent successfully and metadata.Error otherwise.
+	// It is called on the goroutine completing the record, which is shared with other records, so it must not block.
+	OnAcknowledgement(metadata *RecordMetadata, err error)
+}
+
+// intercept runs the configured interceptor chain on a given record and returns the record to send.
+// The returned record reports to the channel or callback of the given one. Fails the record and returns false if
+// an interceptor aborts the send or panics.
+func (kp *KafkaProducer) intercept(record *ProducerRecord) (*ProducerRecord, bool) {
+	if len(kp.config.Interceptors) == 0 {
+		return record, true
+	}
+
+	record.interceptors = kp.config.Interceptors
+	intercepted := record
+	for _, interceptor := range kp.config.Interceptors {
+		intercepted = kp.onSend(interceptor, intercepted)
+		if intercepted == nil || intercepted.Topic == "" {
+			kp.fail(record, ErrInterceptorAborted)
+			return nil, false
+		}
+	}
+
+	if intercepted != record {
+		intercepted.metadataChan = record.metadataChan
+		intercepted.callback = record.callback
+		intercepted.interceptors = record.interceptors
+	}
+	return intercepted, true
+}
+
+// onSend calls OnSend of a given interceptor with a given record. Returns nil if it panics, the panic is logged.
+func (kp *KafkaProducer) onSend(interceptor ProducerInterceptor, record *ProducerRecord) (intercepted *ProducerRecord) {
+	defer func() {
+		if r := recover(); r != nil {
+			Errorf(kp, "Interceptor for %s panicked: %v", record.Topic, r)
+			intercepted = nil
+		}
+	}()
+	return interceptor.OnSend(record)
+}
+
+// acknowledge hands given metadata to the interceptors of this record. Panics are recovered and logged with a given tag.
+func (pr *ProducerRecord) acknowledge(tag interface{}, metadata *RecordMetadata) {
+	var err error
+	if metadata.Error != ErrNoError {
+		err = metadata.Error
+	}
+
+	for _, interceptor := range pr.interceptors {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					Errorf(tag, "Interceptor for %s:%d panicked: %v", metadata.Topic, metadata.Partition, r)
+				}
+			}()
+			interceptor.OnAcknowledgement(metadata, err)
+		}()
+	}
+}
@@ -0,0 +1,106 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testInterceptor struct {
+	lock   sync.Mutex
+	suffix string
+	drop   string
+	acks   []error
+}
+
+func (ti *testInterceptor) OnSend(record *ProducerRecord) *ProducerRecord {
+	if record.Value == ti.drop {
+		return nil
+	}
+	return &ProducerRecord{Topic: record.Topic, Key: record.Key, Value: record.Value.(string) + ti.suffix}
+}
+
+func (ti *testInterceptor) OnAcknowledgement(metadata *RecordMetadata, err error) {
+	ti.lock.Lock()
+	defer ti.lock.Unlock()
+	ti.acks = append(ti.acks, err)
+}
+
+// capturingPartitioner remembers the last serialized value it partitioned.
+type capturingPartitioner struct {
+	value []byte
+}
+
+func (cp *capturingPartitioner) Partition(record *ProducerRecord, partitions []int32) (int32, error) {
+	cp.value = record.encodedValue
+	return 0, nil
+}
+
+type panickingInterceptor struct{}
+
+func (pi *panickingInterceptor) OnSend(record *ProducerRecord) *ProducerRecord { return record }
+func (pi *panickingInterceptor) OnAcknowledgement(*RecordMetadata, error) {
+	panic("interceptor failure")
+}
+
+type panickingSendInterceptor struct{}
+
+func (pi *panickingSendInterceptor) OnSend(record *ProducerRecord) *ProducerRecord {
+	panic("interceptor failure")
+}
+func (pi *panickingSendInterceptor) OnAcknowledgement(*RecordMetadata, error) {}
+
+func TestProducerInterceptorPanicsOnSend(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+	first := &testInterceptor{}
+	producer.config.Interceptors = []ProducerInterceptor{first, &panickingSendInterceptor{}}
+
+	metadata, err := producer.SyncSend(&ProducerRecord{Topic: "siesta", Value: "hello"}, time.Second)
+	assert(t, err, ErrInterceptorAborted)
+	assert(t, metadata.Error, ErrInterceptorAborted)
+	assert(t, first.acks, []error{ErrInterceptorAborted})
+}
+
+func TestProducerInterceptors(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+	first := &testInterceptor{suffix: "!", drop: "drop"}
+	second := &testInterceptor{suffix: "?", drop: "hello!"}
+	producer.config.Interceptors = []ProducerInterceptor{first, &panickingInterceptor{}, second}
+
+	dropped := producer.Send(&ProducerRecord{Topic: "siesta", Value: "drop"})
+	metadata := <-dropped
+	assert(t, metadata.Error, ErrInterceptorAborted)
+
+	droppedLater := producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	metadata = <-droppedLater
+	assert(t, metadata.Error, ErrInterceptorAborted)
+
+	partitioner := new(capturingPartitioner)
+	producer.SetPartitioner("siesta", partitioner)
+	sent := producer.Send(&ProducerRecord{Topic: "siesta", Value: "world"})
+	assert(t, string(partitioner.value), "world!?")
+
+	assertFatal(t, producer.FlushTimeout(time.Second), nil)
+	metadata = <-sent
+	assert(t, metadata.Error, ErrNoError)
+
+	assert(t, first.acks, []error{ErrInterceptorAborted, ErrInterceptorAborted, nil})
+	assert(t, second.acks, []error{ErrInterceptorAborted, ErrInterceptorAborted, nil})
+}
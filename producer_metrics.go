@@ -0,0 +1,139 @@
+package siesta
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MetricType describes how a Metric value should be interpreted.
+type MetricType int
+
+const (
+	// CounterMetric is a monotonically increasing value.
+	CounterMetric MetricType = iota
+
+	// GaugeMetric is a value that can go up and down.
+	GaugeMetric
+
+	// HistogramMetric is a value derived from a distribution of observations, e.g. an average.
+	HistogramMetric
+)
+
+// Metric is a single named value maintained by the producer.
+type Metric struct {
+	Name  string
+	Value float64
+	Tags  map[string]string
+	Type  MetricType
+}
+
+type producerMetrics struct {
+	recordsSent     int64
+	recordErrors    int64
+	serializedBytes int64
+	bytesSent       int64
+	batchesSent     int64
+	requestsSent    int64
+	requestLatency  int64
+
+	// since is the time in Unix nanoseconds the counters were created or last reset at, the base of rates.
+	since int64
+
+	reporter MetricsReporter
+	tags     map[string]string
+}
+
+func newProducerMetrics(reporter MetricsReporter, tags map[string]string) *producerMetrics {
+	if reporter == nil {
+		reporter = NewNoopReporter()
+	}
+
+	return &producerMetrics{
+		since:    time.Now().UnixNano(),
+		reporter: reporter,
+		tags:     tags,
+	}
+}
+
+func (pm *producerMetrics) recordSent() {
+	atomic.AddInt64(&pm.recordsSent, 1)
+	pm.reporter.Count("producer.records-sent-total", 1, pm.tags)
+}
+
+func (pm *producerMetrics) recordError() {
+	atomic.AddInt64(&pm.recordErrors, 1)
+	pm.reporter.Count("producer.record-errors-total", 1, pm.tags)
+}
+
+func (pm *producerMetrics) serialized(bytes int) {
+	atomic.AddInt64(&pm.serializedBytes, int64(bytes))
+	pm.reporter.Count("producer.serialized-bytes-total", int64(bytes), pm.tags)
+}
+
+func (pm *producerMetrics) batchSent(bytes int) {
+	atomic.AddInt64(&pm.batchesSent, 1)
+	atomic.AddInt64(&pm.bytesSent, int64(bytes))
+	pm.reporter.Count("producer.batches-sent-total", 1, pm.tags)
+	pm.reporter.Count("producer.bytes-sent-total", int64(bytes), pm.tags)
+}
+
+func (pm *producerMetrics) requestCompleted(latency time.Duration) {
+	atomic.AddInt64(&pm.requestsSent, 1)
+	atomic.AddInt64(&pm.requestLatency, int64(latency))
+	pm.reporter.Count("producer.requests-sent-total", 1, pm.tags)
+	pm.reporter.Histogram("producer.request-latency", float64(latency)/float64(time.Millisecond), pm.tags)
+}
+
+// reset zeroes all counters. Each counter is reset atomically but not all of them at once,
+// so a record completed concurrently may be counted partially.
+func (pm *producerMetrics) reset() {
+	atomic.StoreInt64(&pm.recordsSent, 0)
+	atomic.StoreInt64(&pm.recordErrors, 0)
+	atomic.StoreInt64(&pm.serializedBytes, 0)
+	atomic.StoreInt64(&pm.bytesSent, 0)
+	atomic.StoreInt64(&pm.batchesSent, 0)
+	atomic.StoreInt64(&pm.requestsSent, 0)
+	atomic.StoreInt64(&pm.requestLatency, 0)
+	atomic.StoreInt64(&pm.since, time.Now().UnixNano())
+}
+
+func (pm *producerMetrics) snapshot(tags map[string]string) map[string]Metric {
+	recordsSent := atomic.LoadInt64(&pm.recordsSent)
+	recordErrors := atomic.LoadInt64(&pm.recordErrors)
+	requestsSent := atomic.LoadInt64(&pm.requestsSent)
+	requestLatency := atomic.LoadInt64(&pm.requestLatency)
+
+	errorRatio := 0.0
+	if total := recordsSent + recordErrors; total > 0 {
+		errorRatio = float64(recordErrors) / float64(total)
+	}
+
+	errorRate := 0.0
+	if elapsed := time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&pm.since)); elapsed > 0 {
+		errorRate = float64(recordErrors) / elapsed.Seconds()
+	}
+
+	latencyAvg := 0.0
+	if requestsSent > 0 {
+		latencyAvg = float64(requestLatency) / float64(requestsSent) / float64(time.Millisecond)
+	}
+
+	metrics := make(map[string]Metric)
+	add := func(name string, value float64, metricType MetricType) {
+		metrics[name] = Metric{Name: name, Value: value, Tags: tags, Type: metricType}
+	}
+
+	add("producer.records-sent-total", float64(recordsSent), CounterMetric)
+	add("producer.record-errors-total", float64(recordErrors), CounterMetric)
+	// failed records per second since the metrics were created or reset
+	add("producer.record-error-rate", errorRate, GaugeMetric)
+	// the share of failed records among all completed ones
+	add("producer.record-error-ratio", errorRatio, GaugeMetric)
+	add("producer.serialized-bytes-total", float64(atomic.LoadInt64(&pm.serializedBytes)), CounterMetric)
+	add("producer.bytes-sent-total", float64(atomic.LoadInt64(&pm.bytesSent)), CounterMetric)
+	add("producer.batches-sent-total", float64(atomic.LoadInt64(&pm.batchesSent)), CounterMetric)
+	add("producer.requests-sent-total", float64(requestsSent), CounterMetric)
+	add("producer.request-latency-avg", latencyAvg, HistogramMetric)
+
+	return metrics
+}
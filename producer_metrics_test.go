@@ -0,0 +1,108 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProducerMetricsSnapshot(t *testing.T) {
+	tags := map[string]string{"client-id": "siesta"}
+	metrics := newProducerMetrics(nil, tags)
+
+	empty := metrics.snapshot(tags)
+	assert(t, empty["producer.records-sent-total"].Value, 0.0)
+	assert(t, empty["producer.record-error-ratio"].Value, 0.0)
+	assert(t, empty["producer.record-error-rate"].Value, 0.0)
+	assert(t, empty["producer.request-latency-avg"].Value, 0.0)
+
+	metrics.serialized(10)
+	metrics.batchSent(10)
+	metrics.recordSent()
+	metrics.recordSent()
+	metrics.recordSent()
+	metrics.recordError()
+	metrics.requestCompleted(10 * time.Millisecond)
+	metrics.requestCompleted(20 * time.Millisecond)
+
+	snapshot := metrics.snapshot(tags)
+	assert(t, snapshot["producer.records-sent-total"].Value, 3.0)
+	assert(t, snapshot["producer.records-sent-total"].Type, CounterMetric)
+	assert(t, snapshot["producer.records-sent-total"].Tags, tags)
+	assert(t, snapshot["producer.record-errors-total"].Value, 1.0)
+	assert(t, snapshot["producer.record-error-ratio"].Value, 0.25)
+	assert(t, snapshot["producer.record-error-ratio"].Type, GaugeMetric)
+	assert(t, snapshot["producer.record-error-rate"].Value > 0, true)
+	assert(t, snapshot["producer.record-error-rate"].Type, GaugeMetric)
+	assert(t, snapshot["producer.serialized-bytes-total"].Value, 10.0)
+	assert(t, snapshot["producer.bytes-sent-total"].Value, 10.0)
+	assert(t, snapshot["producer.batches-sent-total"].Value, 1.0)
+	assert(t, snapshot["producer.requests-sent-total"].Value, 2.0)
+	assert(t, snapshot["producer.request-latency-avg"].Value, 15.0)
+	assert(t, snapshot["producer.request-latency-avg"].Name, "producer.request-latency-avg")
+}
+
+func TestProducerMetricsClientSideErrors(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	metadata := <-producer.Send(&ProducerRecord{Topic: "siesta", Value: 1})
+	assertNot(t, metadata.Error, nil)
+	metadata = <-producer.Send(&ProducerRecord{Topic: "unknown", Value: "hello world"})
+	assertNot(t, metadata.Error, nil)
+
+	metrics := producer.Metrics()
+	assert(t, metrics["producer.record-errors-total"].Value, 2.0)
+	assert(t, metrics["producer.record-error-ratio"].Value, 1.0)
+}
+
+func TestProducerResetMetrics(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	<-producer.Send(&ProducerRecord{Topic: "unknown", Value: "hello world"})
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	assert(t, producer.Metrics()["producer.record-errors-total"].Value, 1.0)
+	assert(t, producer.Metrics()["producer.records-sent-total"].Value, 1.0)
+	assert(t, producer.accumulator.PerPartitionStats()[TopicPartition{Topic: "siesta", Partition: 0}].BatchCount, int64(1))
+
+	producer.ResetMetrics()
+	for name, metric := range producer.Metrics() {
+		if metric.Value != 0 {
+			t.Errorf("Metric %s was not reset: %f", name, metric.Value)
+		}
+	}
+	assert(t, producer.accumulator.PerPartitionStats()[TopicPartition{Topic: "siesta", Partition: 0}].BatchCount, int64(0))
+	assert(t, producer.NetworkMetrics(), NetworkMetrics{})
+}
+
+func TestProducerResetMetricsAfterClose(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	producer.Close(time.Second)
+
+	reset := make(chan bool, 1)
+	go func() {
+		producer.ResetMetrics()
+		reset <- true
+	}()
+	select {
+	case <-reset:
+	case <-time.After(time.Second):
+		t.Error("ResetMetrics blocked after Close")
+	}
+}
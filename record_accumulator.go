@@ -1,26 +1,321 @@
 package siesta
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
 
 type RecordAccumulatorConfig struct {
 	batchSize         int
+	maxRequestSize    int
 	totalMemorySize   int
 	compressionType   string
 	linger            time.Duration
 	retryBackoff      time.Duration
 	blockOnBufferFull bool
-	metrics           map[string]Metric
+	metrics           *producerMetrics
 	time              time.Time
 	metricTags        map[string]string
 	networkClient     *NetworkClient
+	pending           *pendingRecords
+	batchFlushed      func(topic string, partition int32)
 }
 
 type RecordBatch struct {
 	sync.RWMutex
-	batch []*ProducerRecord
+	batch      []*ProducerRecord
+	bytes      int64
+	firstAdded time.Time
+	drained    int64
+}
+
+// PartitionAccumulatorStats describes the state of the accumulator for a single partition.
+type PartitionAccumulatorStats struct {
+	// PendingRecords is the number of records handed to the accumulator but not yet sent to the network client.
+	PendingRecords int64
+
+	// PendingBytes is the serialized size of the keys and values of the pending records in the current batch.
+	PendingBytes int64
+
+	// OldestRecordAge is the time since the first record of the current batch was added, 0 if the batch is empty.
+	OldestRecordAge time.Duration
+
+	// BatchCount is the number of batches sent for this partition so far.
+	BatchCount int64
+}
+
+// TopicAccumulatorStats aggregates the PartitionAccumulatorStats of all partitions of a topic.
+type TopicAccumulatorStats struct {
+	// PendingRecords is the number of records handed to the accumulator but not yet sent to the network client.
+	PendingRecords int64
+
+	// PendingBytes is the serialized size of the keys and values of the pending records in the current batches.
+	PendingBytes int64
+
+	// BatchCount is the number of batches sent for this topic so far.
+	BatchCount int64
+
+	// PartitionCount is the number of partitions the accumulator has seen records for.
+	PartitionCount int
+}
+
+// minMessageSetSize is the size of a message set holding a single v0 message with empty key and value.
+const minMessageSetSize = 26
+
+type rawBatch struct {
+	topic     string
+	partition int32
+	data      []byte
+}
+
+type flushRequest struct {
+	topic     string
+	partition int32
+	flushed   chan bool
+
+	// drained receives the records of the batch instead of sending them if set.
+	drained chan []*ProducerRecord
+}
+
+// bufferUsage keeps track of the serialized size of records accepted by the accumulator but not yet sent
+// and signals whether there is space for more.
+type bufferUsage struct {
+	lock      sync.Mutex
+	limit     int64
+	bytes     int64
+	full      bool
+	available chan struct{}
+
+	highWatermark float64
+	lowWatermark  float64
+	watermarkFn   func(level string)
+	aboveHigh     bool
+}
+
+func newBufferUsage(limit int) *bufferUsage {
+	available := make(chan struct{})
+	close(available)
+	return &bufferUsage{limit: int64(limit), available: available}
+}
+
+// reserve accounts for given bytes accepted by the accumulator. The buffer is full once the limit is reached.
+func (bu *bufferUsage) reserve(bytes int64) {
+	inLock(&bu.lock, func() {
+		bu.bytes += bytes
+		if bu.limit > 0 && !bu.full && bu.bytes >= bu.limit {
+			bu.full = true
+			bu.available = make(chan struct{})
+		}
+		bu.checkWatermarks()
+	})
+}
+
+// release accounts for given bytes handed to the network client. A full buffer has space again once it drops to 80% of the limit.
+func (bu *bufferUsage) release(bytes int64) {
+	inLock(&bu.lock, func() {
+		bu.bytes -= bytes
+		if bu.full && bu.bytes*5 <= bu.limit*4 {
+			bu.full = false
+			close(bu.available)
+		}
+		bu.checkWatermarks()
+	})
+}
+
+func (bu *bufferUsage) utilization() float64 {
+	if bu.limit <= 0 {
+		return 0
+	}
+	return float64(bu.bytes) / float64(bu.limit)
+}
+
+// checkWatermarks fires the watermark callback once per crossing. Must be called with the lock held.
+func (bu *bufferUsage) checkWatermarks() {
+	if bu.watermarkFn == nil || bu.limit <= 0 {
+		return
+	}
+
+	utilization := bu.utilization()
+	if !bu.aboveHigh && utilization >= bu.highWatermark {
+		bu.aboveHigh = true
+		bu.watermarkFn("high")
+	} else if bu.aboveHigh && utilization <= bu.lowWatermark {
+		bu.aboveHigh = false
+		bu.watermarkFn("low")
+	}
+}
+
+func (bu *bufferUsage) setWatermarkCallback(highWatermark float64, lowWatermark float64, fn func(level string)) {
+	inLock(&bu.lock, func() {
+		bu.highWatermark = highWatermark
+		bu.lowWatermark = lowWatermark
+		bu.watermarkFn = fn
+		bu.aboveHigh = false
+		bu.checkWatermarks()
+	})
+}
+
+func (bu *bufferUsage) signal() (available <-chan struct{}) {
+	inLock(&bu.lock, func() {
+		available = bu.available
+	})
+	return available
+}
+
+// pendingRecords keeps track of records that were accepted by the producer but not yet acknowledged, per topic and partition.
+// Records failed by GracefulClose are abandoned, so that a late acknowledgement does not complete them a second time.
+type pendingRecords struct {
+	lock       sync.Mutex
+	counts     map[string]map[int32]int
+	records    map[*ProducerRecord]bool
+	abandoned  map[*ProducerRecord]bool
+	waiters    map[string]map[int32][]chan bool
+	collectors map[*failureCollector]bool
+}
+
+// failureCollector gathers the metadata of records that failed while it is registered with pendingRecords.
+type failureCollector struct {
+	failed []*RecordMetadata
+}
+
+func newPendingRecords() *pendingRecords {
+	return &pendingRecords{
+		counts:     make(map[string]map[int32]int),
+		records:    make(map[*ProducerRecord]bool),
+		abandoned:  make(map[*ProducerRecord]bool),
+		waiters:    make(map[string]map[int32][]chan bool),
+		collectors: make(map[*failureCollector]bool),
+	}
+}
+
+func (pr *pendingRecords) add(record *ProducerRecord) {
+	inLock(&pr.lock, func() {
+		if pr.counts[record.Topic] == nil {
+			pr.counts[record.Topic] = make(map[int32]int)
+		}
+		pr.counts[record.Topic][record.partition]++
+		pr.records[record] = true
+	})
+}
+
+// claim marks a given record as being completed by the caller, who must then call done for it.
+// Returns false if the record was abandoned and already completed, the caller must not complete it then.
+func (pr *pendingRecords) claim(record *ProducerRecord) (claimed bool) {
+	inLock(&pr.lock, func() {
+		if pr.abandoned[record] {
+			delete(pr.abandoned, record)
+			return
+		}
+		delete(pr.records, record)
+		claimed = true
+	})
+	return claimed
+}
+
+// abandonAll claims all records that are not being completed yet and marks them as abandoned, so that later claims
+// for them fail. The caller must complete them and call done for each.
+func (pr *pendingRecords) abandonAll() (records []*ProducerRecord) {
+	inLock(&pr.lock, func() {
+		for record := range pr.records {
+			records = append(records, record)
+			pr.abandoned[record] = true
+		}
+		pr.records = make(map[*ProducerRecord]bool)
+	})
+	return records
+}
+
+func (pr *pendingRecords) done(topic string, partition int32) {
+	inLock(&pr.lock, func() {
+		if pr.counts[topic] == nil || pr.counts[topic][partition] == 0 {
+			return
+		}
+		pr.counts[topic][partition]--
+		if pr.counts[topic][partition] == 0 && pr.waiters[topic] != nil {
+			for _, waiter := range pr.waiters[topic][partition] {
+				close(waiter)
+			}
+			delete(pr.waiters[topic], partition)
+		}
+	})
+}
+
+// fail hands the metadata of a failed record to all registered failure collectors. It must be called before done
+// for the same record, so that whoever awaits the partition sees the failure.
+func (pr *pendingRecords) fail(metadata *RecordMetadata) {
+	inLock(&pr.lock, func() {
+		for collector := range pr.collectors {
+			collector.failed = append(collector.failed, metadata)
+		}
+	})
+}
+
+// collectFailures registers a new failureCollector, which receives failures until it is passed to stopCollecting.
+func (pr *pendingRecords) collectFailures() (collector *failureCollector) {
+	collector = new(failureCollector)
+	inLock(&pr.lock, func() {
+		pr.collectors[collector] = true
+	})
+	return collector
+}
+
+// stopCollecting unregisters a given failureCollector and returns the failures it gathered.
+func (pr *pendingRecords) stopCollecting(collector *failureCollector) (failed []*RecordMetadata) {
+	inLock(&pr.lock, func() {
+		delete(pr.collectors, collector)
+		failed = collector.failed
+	})
+	return failed
+}
+
+// topics returns the sorted names of topics with at least one pending record.
+func (pr *pendingRecords) topics() []string {
+	topics := make([]string, 0)
+	inLock(&pr.lock, func() {
+		for topic, partitions := range pr.counts {
+			for _, count := range partitions {
+				if count > 0 {
+					topics = append(topics, topic)
+					break
+				}
+			}
+		}
+	})
+	sort.Strings(topics)
+	return topics
+}
+
+// partitions returns all topic partitions with at least one pending record.
+func (pr *pendingRecords) partitions() []TopicPartition {
+	partitions := make([]TopicPartition, 0)
+	inLock(&pr.lock, func() {
+		for topic, counts := range pr.counts {
+			for partition, count := range counts {
+				if count > 0 {
+					partitions = append(partitions, TopicPartition{Topic: topic, Partition: partition})
+				}
+			}
+		}
+	})
+	return partitions
+}
+
+// await returns a channel that is closed once there are no pending records for a given topic and partition.
+func (pr *pendingRecords) await(topic string, partition int32) <-chan bool {
+	waiter := make(chan bool)
+	inLock(&pr.lock, func() {
+		if pr.counts[topic] == nil || pr.counts[topic][partition] == 0 {
+			close(waiter)
+			return
+		}
+		if pr.waiters[topic] == nil {
+			pr.waiters[topic] = make(map[int32][]chan bool)
+		}
+		pr.waiters[topic][partition] = append(pr.waiters[topic][partition], waiter)
+	})
+	return waiter
 }
 
 type RecordAccumulator struct {
@@ -30,10 +325,21 @@ type RecordAccumulator struct {
 	batches       map[string]map[int32]*RecordBatch
 
 	addChan      chan *ProducerRecord
+	rawChan      chan *rawBatch
+	flushChan    chan *flushRequest
+	statsChan    chan chan map[TopicPartition]*PartitionAccumulatorStats
+	resetChan    chan chan bool
 	closing      chan bool
 	closed       chan bool
+	senderDone   chan struct{}
 	metadataChan chan *RecordMetadata
 	records      map[string]map[int32]chan *ProducerRecord
+	flushes      map[string]map[int32]chan *flushRequest
+	pending      *pendingRecords
+	buffer       *bufferUsage
+	faults       *accumulatorFaults
+	stopped      bool
+	stoppedLock  sync.RWMutex
 }
 
 func NewRecordAccumulator(config *RecordAccumulatorConfig, metadataChan chan *RecordMetadata) *RecordAccumulator {
@@ -41,12 +347,24 @@ func NewRecordAccumulator(config *RecordAccumulatorConfig, metadataChan chan *Re
 	accumulator.config = config
 	accumulator.batchSize = config.batchSize
 	accumulator.addChan = make(chan *ProducerRecord, 100) //TODO config
+	accumulator.rawChan = make(chan *rawBatch, 100)
+	accumulator.flushChan = make(chan *flushRequest)
+	accumulator.statsChan = make(chan chan map[TopicPartition]*PartitionAccumulatorStats)
+	accumulator.resetChan = make(chan chan bool)
 	accumulator.batches = make(map[string]map[int32]*RecordBatch)
 	accumulator.networkClient = config.networkClient
 	accumulator.closing = make(chan bool)
-	accumulator.closed = make(chan bool)
+	accumulator.closed = make(chan bool, 1)
+	accumulator.senderDone = make(chan struct{})
 	accumulator.metadataChan = metadataChan
 	accumulator.records = make(map[string]map[int32]chan *ProducerRecord)
+	accumulator.flushes = make(map[string]map[int32]chan *flushRequest)
+	accumulator.buffer = newBufferUsage(config.totalMemorySize)
+	accumulator.faults = newAccumulatorFaults()
+	accumulator.pending = config.pending
+	if accumulator.pending == nil {
+		accumulator.pending = newPendingRecords()
+	}
 
 	go accumulator.sender()
 
@@ -67,6 +385,15 @@ func (ra *RecordAccumulator) sender() {
 					return
 				case record := <-ra.addChan:
 					ra.addRecord(record)
+				case batch := <-ra.rawChan:
+					ra.addRawBatch(batch)
+				case request := <-ra.flushChan:
+					ra.flushPartition(request)
+				case stats := <-ra.statsChan:
+					stats <- ra.partitionStats()
+				case reset := <-ra.resetChan:
+					ra.resetStats()
+					reset <- true
 				}
 			}
 		}
@@ -77,6 +404,7 @@ func (ra *RecordAccumulator) cleanup() {
 	close(ra.addChan)
 	ra.flushAll()
 	ra.networkClient.close()
+	close(ra.senderDone)
 	ra.closed <- true
 }
 
@@ -90,6 +418,174 @@ func (ra *RecordAccumulator) addRecord(record *ProducerRecord) {
 	ra.records[record.Topic][record.partition] <- record
 }
 
+// add hands given records to the sender. Returns false if the accumulator no longer accepts records, none are added then.
+func (ra *RecordAccumulator) add(records ...*ProducerRecord) (accepted bool) {
+	inReadLock(&ra.stoppedLock, func() {
+		if ra.stopped {
+			return
+		}
+		for _, record := range records {
+			ra.pending.add(record)
+			ra.buffer.reserve(int64(len(record.encodedKey) + len(record.encodedValue)))
+			ra.addChan <- record
+		}
+		accepted = true
+	})
+	return accepted
+}
+
+// stop makes the accumulator reject all further records.
+func (ra *RecordAccumulator) stop() {
+	inWriteLock(&ra.stoppedLock, func() {
+		ra.stopped = true
+	})
+}
+
+// AddRawBatch hands an already encoded message set for a given topic and partition directly to the network client,
+// bypassing serialization and batching. Records accumulated for the partition so far are flushed first, but the two
+// requests may be sent over different connections, so there is no ordering guarantee between them and the raw batch.
+// The message set must use message format v0 and must not exceed the configured MaxRequestSize (if set).
+// The batch is sent as is, it is never re-compressed. Delivery failures are logged as there are no records to report them to.
+// Returns ErrProducerClosing once the accumulator no longer accepts records.
+func (ra *RecordAccumulator) AddRawBatch(topic string, partition int32, data []byte) error {
+	if ra.config.maxRequestSize > 0 && len(data) > ra.config.maxRequestSize {
+		return ErrMessageSizeTooLarge
+	}
+
+	if len(data) < minMessageSetSize {
+		return fmt.Errorf("Raw batch of %d bytes is too short to contain a message", len(data))
+	}
+	// offset (8) + message size (4) + crc (4) precede the magic byte of the first message
+	if magic := int8(data[16]); magic != 0 {
+		return fmt.Errorf("Unsupported magic byte %d in raw batch", magic)
+	}
+
+	err := ErrProducerClosing
+	inReadLock(&ra.stoppedLock, func() {
+		if ra.stopped {
+			return
+		}
+		ra.rawChan <- &rawBatch{topic: topic, partition: partition, data: data}
+		err = nil
+	})
+	return err
+}
+
+func (ra *RecordAccumulator) addRawBatch(batch *rawBatch) {
+	if ra.batches[batch.topic] != nil && ra.batches[batch.topic][batch.partition] != nil {
+		ra.flush(batch.topic, batch.partition, ra.batches[batch.topic][batch.partition])
+	}
+	ra.networkClient.sendMessageSet(batch.topic, batch.partition, batch.data)
+}
+
+// flushPartition sends everything accumulated for a given topic and partition right away.
+// Records that were accepted by Send before the flush was requested but are still queued are included.
+func (ra *RecordAccumulator) flushPartition(request *flushRequest) {
+	ra.drainAddChan()
+
+	if ra.flushes[request.topic] == nil || ra.flushes[request.topic][request.partition] == nil {
+		request.flushed <- true
+		return
+	}
+	ra.flushes[request.topic][request.partition] <- request
+}
+
+// drainAddChan adds all records that are already queued in addChan without blocking.
+func (ra *RecordAccumulator) drainAddChan() {
+	for drained := false; !drained; {
+		select {
+		case record := <-ra.addChan:
+			ra.addRecord(record)
+		default:
+			drained = true
+		}
+	}
+}
+
+// awaitPartition asks the accumulator to flush a given topic and partition and blocks until all records
+// pending for it are acknowledged or the timeout elapses. Returns ErrFlushTimeout in the latter case.
+func (ra *RecordAccumulator) awaitPartition(topic string, partition int32, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	request := &flushRequest{topic: topic, partition: partition, flushed: make(chan bool, 1)}
+	select {
+	case ra.flushChan <- request:
+	case <-deadline:
+		return ErrFlushTimeout
+	}
+
+	select {
+	case <-request.flushed:
+	case <-deadline:
+		return ErrFlushTimeout
+	}
+
+	select {
+	case <-ra.pending.await(topic, partition):
+		return nil
+	case <-deadline:
+		return ErrFlushTimeout
+	}
+}
+
+// awaitAll asks the accumulator to flush every partition with pending records and blocks until they are acknowledged
+// or the timeout elapses, waiting indefinitely if the timeout is not positive. Returns ErrFlushTimeout in the latter case
+// and a *FlushError if any record failed in the meantime.
+func (ra *RecordAccumulator) awaitAll(timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	collector := ra.pending.collectFailures()
+	defer ra.pending.stopCollecting(collector)
+
+	partitions := ra.pending.partitions()
+	requests := make([]*flushRequest, 0, len(partitions))
+	timedOut := false
+	inReadLock(&ra.stoppedLock, func() {
+		// a stopped accumulator flushes everything on its own and may no longer serve flush requests
+		if ra.stopped {
+			return
+		}
+		for _, partition := range partitions {
+			request := &flushRequest{topic: partition.Topic, partition: partition.Partition, flushed: make(chan bool, 1)}
+			select {
+			case ra.flushChan <- request:
+				requests = append(requests, request)
+			case <-deadline:
+				timedOut = true
+				return
+			}
+		}
+	})
+	if timedOut {
+		return ErrFlushTimeout
+	}
+
+	for _, request := range requests {
+		select {
+		case <-request.flushed:
+		case <-deadline:
+			return ErrFlushTimeout
+		}
+	}
+
+	for _, partition := range partitions {
+		select {
+		case <-ra.pending.await(partition.Topic, partition.Partition):
+		case <-deadline:
+			return ErrFlushTimeout
+		}
+	}
+
+	if failed := ra.pending.stopCollecting(collector); len(failed) > 0 {
+		return &FlushError{Failed: failed}
+	}
+	return nil
+}
+
 func (ra *RecordAccumulator) createBatch(topic string, partition int32) {
 	batch := make([]*ProducerRecord, 0, ra.batchSize)
 	ra.batches[topic][partition] = &RecordBatch{batch: batch}
@@ -97,57 +593,297 @@ func (ra *RecordAccumulator) createBatch(topic string, partition int32) {
 		ra.records[topic] = make(map[int32]chan *ProducerRecord)
 	}
 	ra.records[topic][partition] = make(chan *ProducerRecord, ra.batchSize)
-	go ra.watcher(topic, partition)
+	if ra.flushes[topic] == nil {
+		ra.flushes[topic] = make(map[int32]chan *flushRequest)
+	}
+	ra.flushes[topic][partition] = make(chan *flushRequest)
+	go ra.watcher(topic, partition, ra.batches[topic][partition], ra.records[topic][partition], ra.flushes[topic][partition])
 }
 
-func (ra *RecordAccumulator) watcher(topic string, partition int32) {
+// watcher owns the batch for a given topic and partition and never touches the batches map, which belongs to the sender.
+func (ra *RecordAccumulator) watcher(topic string, partition int32, batch *RecordBatch, records chan *ProducerRecord, flushes chan *flushRequest) {
 	timeout := time.NewTimer(ra.config.linger)
 	for {
 		select {
-		case record := <-ra.records[topic][partition]:
-			batch := ra.batches[record.Topic][record.partition]
-			batch.Lock()
-			batch.batch = append(batch.batch, record)
-			batch.Unlock()
+		case record := <-records:
+			batch.append(record)
+		case request := <-flushes:
+			for drained := false; !drained; {
+				select {
+				case record := <-records:
+					batch.append(record)
+				default:
+					drained = true
+				}
+			}
+			if request.drained != nil {
+				request.drained <- ra.drain(batch)
+			} else {
+				ra.flush(topic, partition, batch)
+			}
+			timeout.Reset(ra.config.linger)
+			request.flushed <- true
+			continue
 		case <-timeout.C:
-			ra.flush(topic, partition)
+			ra.flush(topic, partition, batch)
 			timeout.Reset(ra.config.linger)
 		case <-ra.closing:
 			ra.closing <- true
 			timeout.Stop()
 			return
 		}
-		batch := ra.batches[topic][partition]
 		batch.RLock()
 		lenght := len(batch.batch)
 		batch.RUnlock()
 		if lenght >= ra.batchSize {
-			ra.flush(topic, partition)
+			ra.flush(topic, partition, batch)
 			timeout.Reset(ra.config.linger)
 		}
 	}
-	timeout.Stop()
 }
 
-func (ra *RecordAccumulator) flush(topic string, partition int32) {
-	batch := ra.batches[topic][partition]
+func (rb *RecordBatch) append(record *ProducerRecord) {
+	rb.Lock()
+	if len(rb.batch) == 0 {
+		rb.firstAdded = time.Now()
+	}
+	rb.batch = append(rb.batch, record)
+	rb.bytes += int64(len(record.encodedKey) + len(record.encodedValue))
+	rb.Unlock()
+}
+
+func (ra *RecordAccumulator) flush(topic string, partition int32, batch *RecordBatch) {
 	batch.Lock()
 	defer batch.Unlock()
-	if len(ra.batches[topic][partition].batch) > 0 {
-		ra.networkClient.send(topic, partition, batch.batch)
+	if len(batch.batch) > 0 {
+		if ra.faults.beforeSend(ra, topic, partition, batch.batch) {
+			ra.networkClient.send(topic, partition, batch.batch)
+		}
 		batch.batch = make([]*ProducerRecord, 0, ra.batchSize)
+		ra.buffer.release(batch.bytes)
+		batch.bytes = 0
+		batch.drained++
+		if ra.config.batchFlushed != nil {
+			ra.config.batchFlushed(topic, partition)
+		}
+	}
+}
+
+// drain empties a given batch and returns its records instead of sending them. They are no longer pending.
+func (ra *RecordAccumulator) drain(batch *RecordBatch) (records []*ProducerRecord) {
+	inWriteLock(&batch.RWMutex, func() {
+		records = batch.batch
+		batch.batch = make([]*ProducerRecord, 0, ra.batchSize)
+		ra.buffer.release(batch.bytes)
+		batch.bytes = 0
+	})
+	claimed := records[:0]
+	for _, record := range records {
+		if ra.pending.claim(record) {
+			claimed = append(claimed, record)
+		}
+		ra.pending.done(record.Topic, record.partition)
+	}
+	return claimed
+}
+
+// drainOnePartition removes and returns all records accumulated for a given partition without sending them,
+// including the ones still queued. Returns ErrPartitionNotFound if there are none. Meant for tests.
+func (ra *RecordAccumulator) drainOnePartition(tp TopicPartition) ([]*ProducerRecord, error) {
+	request := &flushRequest{
+		topic:     tp.Topic,
+		partition: tp.Partition,
+		flushed:   make(chan bool, 1),
+		drained:   make(chan []*ProducerRecord, 1),
+	}
+	ra.flushChan <- request
+	<-request.flushed
+
+	var records []*ProducerRecord
+	select {
+	case records = <-request.drained:
+	default:
+	}
+	if len(records) == 0 {
+		return nil, ErrPartitionNotFound
 	}
+	return records, nil
 }
 
 func (ra *RecordAccumulator) flushAll() {
 	for topic, partitionBatches := range ra.batches {
-		for partition, _ := range partitionBatches {
-			ra.flush(topic, partition)
+		for partition, batch := range partitionBatches {
+			ra.flush(topic, partition, batch)
+		}
+	}
+}
+
+// BackpressureSignal returns a channel that is closed while the accumulator has space for more records.
+// Once the records accepted but not yet sent reach TotalMemorySize, later calls return a channel that stays open
+// until they drop to 80% of it. Always returns a closed channel if TotalMemorySize is not set.
+// Receive from a freshly obtained channel before each Send to wait for space instead of piling up records.
+func (ra *RecordAccumulator) BackpressureSignal() <-chan struct{} {
+	return ra.buffer.signal()
+}
+
+// BufferUtilization returns the size of records accepted but not yet sent as a fraction of TotalMemorySize.
+// Always returns 0 if TotalMemorySize is not set.
+func (ra *RecordAccumulator) BufferUtilization() (utilization float64) {
+	inLock(&ra.buffer.lock, func() {
+		utilization = ra.buffer.utilization()
+	})
+	return utilization
+}
+
+// SetWatermarkCallback registers fn to be called with "high" when BufferUtilization rises to highWatermark
+// and with "low" when it then falls to lowWatermark, once per crossing. A nil fn removes the callback.
+// fn is called synchronously while the buffer usage is updated, either from Send or from the sender goroutine,
+// so it must not block or call back into the accumulator. Never fires if TotalMemorySize is not set.
+func (ra *RecordAccumulator) SetWatermarkCallback(highWatermark float64, lowWatermark float64, fn func(level string)) {
+	ra.buffer.setWatermarkCallback(highWatermark, lowWatermark, fn)
+}
+
+// PerPartitionStats returns the state of every partition the accumulator has seen records for,
+// including records accepted by Send that are still queued.
+// Partitions whose oldest pending record waits for more than three times the linger time are logged as possibly stuck.
+// Returns empty stats once the accumulator is closed.
+func (ra *RecordAccumulator) PerPartitionStats() map[TopicPartition]*PartitionAccumulatorStats {
+	stats := make(chan map[TopicPartition]*PartitionAccumulatorStats, 1)
+	select {
+	case ra.statsChan <- stats:
+		return <-stats
+	case <-ra.senderDone:
+		return make(map[TopicPartition]*PartitionAccumulatorStats)
+	}
+}
+
+// TopicStats returns the state of the accumulator per topic, aggregated over all partitions it has seen records for.
+// It is taken from the same snapshot as PerPartitionStats would be, so the numbers of a topic are consistent.
+func (ra *RecordAccumulator) TopicStats() map[string]*TopicAccumulatorStats {
+	stats := make(map[string]*TopicAccumulatorStats)
+	for tp, partitionStats := range ra.PerPartitionStats() {
+		topicStats := stats[tp.Topic]
+		if topicStats == nil {
+			topicStats = new(TopicAccumulatorStats)
+			stats[tp.Topic] = topicStats
+		}
+		topicStats.PendingRecords += partitionStats.PendingRecords
+		topicStats.PendingBytes += partitionStats.PendingBytes
+		topicStats.BatchCount += partitionStats.BatchCount
+		topicStats.PartitionCount++
+	}
+	return stats
+}
+
+func (ra *RecordAccumulator) partitionStats() map[TopicPartition]*PartitionAccumulatorStats {
+	ra.drainAddChan()
+	stats := make(map[TopicPartition]*PartitionAccumulatorStats)
+	for topic, partitionBatches := range ra.batches {
+		for partition, batch := range partitionBatches {
+			partitionStats := new(PartitionAccumulatorStats)
+			inReadLock(&batch.RWMutex, func() {
+				partitionStats.PendingRecords = int64(len(batch.batch) + len(ra.records[topic][partition]))
+				partitionStats.PendingBytes = batch.bytes
+				partitionStats.BatchCount = batch.drained
+				if len(batch.batch) > 0 {
+					partitionStats.OldestRecordAge = time.Since(batch.firstAdded)
+				}
+			})
+
+			if partitionStats.OldestRecordAge > 3*ra.config.linger {
+				Warnf(ra, "Partition %s:%d may be stuck, oldest record was added %s ago", topic, partition, partitionStats.OldestRecordAge)
+			}
+			stats[TopicPartition{Topic: topic, Partition: partition}] = partitionStats
+		}
+	}
+
+	return stats
+}
+
+// ResetStats zeroes the number of batches drained for every partition.
+// Pending records and bytes describe the current state of the accumulator and are not affected.
+// Does nothing once the accumulator is closed.
+func (ra *RecordAccumulator) ResetStats() {
+	reset := make(chan bool, 1)
+	select {
+	case ra.resetChan <- reset:
+		<-reset
+	case <-ra.senderDone:
+	}
+}
+
+func (ra *RecordAccumulator) resetStats() {
+	for _, partitionBatches := range ra.batches {
+		for _, batch := range partitionBatches {
+			inWriteLock(&batch.RWMutex, func() {
+				batch.drained = 0
+			})
+		}
+	}
+}
+
+func (ra *RecordAccumulator) String() string {
+	return "Record Accumulator"
+}
+
+// GracefulClose stops accepting records, flushes everything accumulated so far and waits until it is acknowledged
+// before closing the accumulator. Records sent in the meantime fail with ErrProducerClosing.
+// If the timeout elapses first, all records that are still pending fail with ErrProducerClosing, whether they were
+// sent already or not, the accumulator is closed in the background and an *ErrDrainTimeout is returned.
+// Acknowledgements arriving later are dropped.
+func (ra *RecordAccumulator) GracefulClose(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	ra.stop()
+	partitions := ra.pending.partitions()
+	expired := false
+	for _, partition := range partitions {
+		select {
+		case ra.flushChan <- &flushRequest{topic: partition.Topic, partition: partition.Partition, flushed: make(chan bool, 1)}:
+		case <-timer.C:
+			expired = true
+		}
+		if expired {
+			break
+		}
+	}
+
+	drained := 0
+	for _, partition := range partitions {
+		done := ra.pending.await(partition.Topic, partition.Partition)
+		if !expired {
+			select {
+			case <-done:
+			case <-timer.C:
+				expired = true
+			}
+		}
+		select {
+		case <-done:
+			drained++
+		default:
+		}
+	}
+
+	if drained < len(partitions) {
+		for _, record := range ra.pending.abandonAll() {
+			if ra.config.metrics != nil {
+				ra.config.metrics.recordError()
+			}
+			record.complete(ra, &RecordMetadata{Topic: record.Topic, Partition: record.partition, Error: ErrProducerClosing})
+			ra.pending.done(record.Topic, record.partition)
 		}
+		// the sender may be the reason for the timeout, so closing must not block
+		go ra.close()
+		return &ErrDrainTimeout{DrainedPartitions: drained, RemainingPartitions: len(partitions) - drained}
 	}
+	<-ra.close()
+	return nil
 }
 
 func (ra *RecordAccumulator) close() chan bool {
+	ra.stop()
 	ra.closing <- true
 	return ra.closed
 }
@@ -0,0 +1,99 @@
+//go:build testing
+// +build testing
+
+package siesta
+
+import (
+	"sync"
+	"time"
+)
+
+// AccumulatorFault is a fault injected into a RecordAccumulator with InjectFault. Each fault fires once.
+type AccumulatorFault interface {
+	// fire applies this fault to a batch about to be sent. Returns whether it fired and whether the batch should still be sent.
+	fire(ra *RecordAccumulator, topic string, partition int32, batch []*ProducerRecord) (fired bool, send bool)
+}
+
+// DropNextBatch silently discards the next batch for a given topic and partition, simulating a lost request.
+// Its records are never completed.
+type DropNextBatch struct {
+	Topic     string
+	Partition int32
+}
+
+func (f DropNextBatch) fire(ra *RecordAccumulator, topic string, partition int32, batch []*ProducerRecord) (bool, bool) {
+	if f.Topic != topic || f.Partition != partition {
+		return false, true
+	}
+	return true, false
+}
+
+// DelayNextDrain holds up the next batch of any partition for a given duration before it is sent.
+type DelayNextDrain struct {
+	Duration time.Duration
+}
+
+func (f DelayNextDrain) fire(ra *RecordAccumulator, topic string, partition int32, batch []*ProducerRecord) (bool, bool) {
+	time.Sleep(f.Duration)
+	return true, true
+}
+
+// ErrorOnBatch fails all records of the next batch for a given topic and partition with a given error instead of sending it.
+type ErrorOnBatch struct {
+	Topic     string
+	Partition int32
+	Err       error
+}
+
+func (f ErrorOnBatch) fire(ra *RecordAccumulator, topic string, partition int32, batch []*ProducerRecord) (bool, bool) {
+	if f.Topic != topic || f.Partition != partition {
+		return false, true
+	}
+	ra.networkClient.fail(batch, f.Err)
+	return true, false
+}
+
+type accumulatorFaults struct {
+	lock   sync.Mutex
+	faults []AccumulatorFault
+}
+
+func newAccumulatorFaults() *accumulatorFaults {
+	return new(accumulatorFaults)
+}
+
+// InjectFault makes the accumulator misbehave once as described by a given fault. Only available with the testing build tag.
+func (ra *RecordAccumulator) InjectFault(fault AccumulatorFault) {
+	inLock(&ra.faults.lock, func() {
+		ra.faults.faults = append(ra.faults.faults, fault)
+	})
+}
+
+// beforeSend fires the injected faults, in the order they were injected, for a batch about to be sent.
+// Returns false if the batch must not be sent.
+func (af *accumulatorFaults) beforeSend(ra *RecordAccumulator, topic string, partition int32, batch []*ProducerRecord) bool {
+	var faults []AccumulatorFault
+	inLock(&af.lock, func() {
+		faults = af.faults
+		af.faults = nil
+	})
+
+	send := true
+	remaining := make([]AccumulatorFault, 0, len(faults))
+	for _, fault := range faults {
+		if !send {
+			remaining = append(remaining, fault)
+			continue
+		}
+		var fired bool
+		fired, send = fault.fire(ra, topic, partition, batch)
+		if !fired {
+			remaining = append(remaining, fault)
+		}
+	}
+
+	inLock(&af.lock, func() {
+		af.faults = append(remaining, af.faults...)
+	})
+	return send
+}
@@ -0,0 +1,71 @@
+//go:build testing
+// +build testing
+
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecordAccumulatorInjectFault(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	injected := errors.New("injected")
+	producer.accumulator.InjectFault(ErrorOnBatch{Topic: "siesta", Partition: 0, Err: injected})
+	producer.accumulator.InjectFault(DropNextBatch{Topic: "siesta", Partition: 0})
+	producer.accumulator.InjectFault(DropNextBatch{Topic: "siesta", Partition: 1})
+
+	send := func() <-chan *RecordMetadata {
+		metadata := producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+		// the flush of a dropped batch never completes
+		producer.FlushPartition("siesta", 0, 100*time.Millisecond)
+		return metadata
+	}
+
+	metadata := <-send()
+	assert(t, metadata.Error, injected)
+
+	select {
+	case <-send():
+		t.Error("Dropped batch should not be completed")
+	default:
+	}
+
+	metadata = <-send()
+	assert(t, metadata.Error, ErrNoError)
+	// the fault for partition 1 never fired
+	inLock(&producer.accumulator.faults.lock, func() {
+		assert(t, len(producer.accumulator.faults.faults), 1)
+	})
+}
+
+func TestRecordAccumulatorInjectDelay(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+	producer.accumulator.InjectFault(DelayNextDrain{Duration: 200 * time.Millisecond})
+
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+	assert(t, producer.FlushPartition("siesta", 0, 50*time.Millisecond), ErrFlushTimeout)
+	assert(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	inLock(&producer.accumulator.faults.lock, func() {
+		assert(t, len(producer.accumulator.faults.faults), 0)
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !testing
+// +build !testing
+
+package siesta
+
+// accumulatorFaults is a no-op unless built with the testing tag, see record_accumulator_faults.go.
+type accumulatorFaults struct{}
+
+func newAccumulatorFaults() *accumulatorFaults {
+	return nil
+}
+
+func (af *accumulatorFaults) beforeSend(ra *RecordAccumulator, topic string, partition int32, batch []*ProducerRecord) bool {
+	return true
+}
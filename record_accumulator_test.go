@@ -0,0 +1,337 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordAccumulatorAddRawBatchValidation(t *testing.T) {
+	accumulator := &RecordAccumulator{config: &RecordAccumulatorConfig{maxRequestSize: 64}}
+
+	assert(t, accumulator.AddRawBatch("siesta", 0, make([]byte, 65)), ErrMessageSizeTooLarge)
+	assertNot(t, accumulator.AddRawBatch("siesta", 0, make([]byte, 10)), nil)
+
+	badMagic := append([]byte(nil), goodProduceRequestBytes[30:]...)
+	badMagic[16] = 5
+	assertNot(t, accumulator.AddRawBatch("siesta", 0, badMagic), nil)
+}
+
+func TestRecordAccumulatorAddRawBatchQueued(t *testing.T) {
+	accumulator := &RecordAccumulator{
+		config:  &RecordAccumulatorConfig{maxRequestSize: 1024},
+		rawChan: make(chan *rawBatch, 1),
+	}
+
+	messageSet := goodProduceRequestBytes[30:]
+	assert(t, accumulator.AddRawBatch("siesta", 1, messageSet), nil)
+	select {
+	case batch := <-accumulator.rawChan:
+		assert(t, batch.topic, "siesta")
+		assert(t, batch.partition, int32(1))
+		assert(t, batch.data, messageSet)
+	case <-time.After(time.Second):
+		t.Error("Raw batch was not queued")
+	}
+}
+
+func TestRecordAccumulatorAddRawBatchAfterClose(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	producer.Close(time.Second)
+
+	assert(t, producer.accumulator.AddRawBatch("siesta", 0, goodProduceRequestBytes[30:]), ErrProducerClosing)
+}
+
+func testOfflineProducer(link BrokerLink, requiredAcks int, partitions int32) *KafkaProducer {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": partitions}, link: link}
+	config := NewProducerConfig()
+	config.RequiredAcks = requiredAcks
+	config.Linger = time.Minute
+	config.BatchSize = 100
+	config.SendRoutines = 1
+	config.ReceiveRoutines = 1
+	producer, err := NewKafkaProducer(config, ByteSerializer, StringSerializer, connector)
+	if err != nil {
+		panic(err)
+	}
+	return producer
+}
+
+func TestProducerFlushPartition(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 2)
+
+	metadatas := make([]<-chan *RecordMetadata, 10)
+	for i := range metadatas {
+		metadatas[i] = producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+	}
+
+	assert(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	assert(t, producer.FlushPartition("siesta", 1, time.Second), nil)
+	for _, metadataChan := range metadatas {
+		select {
+		case metadata := <-metadataChan:
+			assert(t, metadata.Error, ErrNoError)
+		default:
+			t.Fatal("Record was not flushed")
+		}
+	}
+
+	// nothing was ever sent to this topic
+	assert(t, producer.FlushPartition("other", 0, time.Second), nil)
+	producer.Close(time.Second)
+}
+
+func TestProducerFlushPartitionTimeout(t *testing.T) {
+	link := newTestBrokerLink(false)
+	producer := testOfflineProducer(link, 1, 1)
+
+	metadata := producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+	assert(t, producer.FlushPartition("siesta", 0, 100*time.Millisecond), ErrFlushTimeout)
+
+	close(link.released)
+	select {
+	case result := <-metadata:
+		assertNot(t, result.Error, ErrNoError)
+	case <-time.After(time.Second):
+		t.Fatal("Record was not completed")
+	}
+	assert(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	producer.Close(time.Second)
+}
+
+func TestRecordAccumulatorPerPartitionStats(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+	assert(t, len(producer.accumulator.PerPartitionStats()), 0)
+
+	for i := 0; i < 3; i++ {
+		producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	}
+
+	siesta := TopicPartition{Topic: "siesta", Partition: 0}
+	var stats *PartitionAccumulatorStats
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		stats = producer.accumulator.PerPartitionStats()[siesta]
+		if stats != nil && stats.PendingBytes == 15 {
+			break
+		}
+	}
+	assertFatal(t, stats != nil, true)
+	assert(t, stats.PendingRecords, int64(3))
+	assert(t, stats.PendingBytes, int64(15))
+	assert(t, stats.BatchCount, int64(0))
+	assert(t, stats.OldestRecordAge > 0, true)
+
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	stats = producer.accumulator.PerPartitionStats()[siesta]
+	assert(t, stats, &PartitionAccumulatorStats{BatchCount: 1})
+}
+
+func TestRecordAccumulatorGracefulClose(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 1, 2)
+
+	metadatas := make([]<-chan *RecordMetadata, 10)
+	for i := range metadatas {
+		metadatas[i] = producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+	}
+
+	assert(t, producer.accumulator.GracefulClose(time.Second), nil)
+	for _, metadataChan := range metadatas {
+		select {
+		case metadata := <-metadataChan:
+			assertNot(t, metadata.Error, ErrProducerClosing)
+		default:
+			t.Fatal("Record was not drained")
+		}
+	}
+
+	metadata := <-producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello world"})
+	assert(t, metadata.Error, ErrProducerClosing)
+}
+
+func TestRecordAccumulatorGracefulCloseTimeout(t *testing.T) {
+	link := newTestBrokerLink(false)
+	producer := testOfflineProducer(link, 1, 2)
+
+	metadatas := []<-chan *RecordMetadata{
+		producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte{0}, Value: "hello world"}),
+		producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte{0}, Value: "hello world"}),
+		producer.Send(&ProducerRecord{Topic: "siesta", Key: []byte{1}, Value: "hello world"}),
+	}
+
+	err := producer.accumulator.GracefulClose(100 * time.Millisecond)
+	assert(t, err, &ErrDrainTimeout{DrainedPartitions: 0, RemainingPartitions: 2})
+	for _, metadataChan := range metadatas {
+		select {
+		case metadata := <-metadataChan:
+			assert(t, metadata.Error, ErrProducerClosing)
+		default:
+			t.Fatal("Pending record was not failed on timeout")
+		}
+	}
+	close(link.released)
+}
+
+func TestRecordAccumulatorBackpressureSignal(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta": 1}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	config.Linger = time.Minute
+	config.TotalMemorySize = 50
+	producer, err := NewKafkaProducer(config, ByteSerializer, StringSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+
+	signal := producer.BackpressureSignal()
+	select {
+	case <-signal:
+	default:
+		t.Fatal("Empty accumulator should have space")
+	}
+
+	for i := 0; i < 5; i++ {
+		producer.Send(&ProducerRecord{Topic: "siesta", Value: "helloworld"})
+	}
+	signal = producer.accumulator.BackpressureSignal()
+	select {
+	case <-signal:
+		t.Fatal("Full accumulator should not have space")
+	default:
+	}
+
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+	select {
+	case <-signal:
+	case <-time.After(time.Second):
+		t.Fatal("Accumulator should have space after flushing")
+	}
+	inLock(&producer.accumulator.buffer.lock, func() {
+		assert(t, producer.accumulator.buffer.bytes, int64(0))
+	})
+}
+
+func TestBufferUsageHysteresis(t *testing.T) {
+	buffer := newBufferUsage(100)
+	buffer.reserve(100)
+	full := buffer.signal()
+
+	buffer.release(10)
+	select {
+	case <-full:
+		t.Fatal("Buffer at 90% should still be full")
+	default:
+	}
+
+	buffer.release(10)
+	select {
+	case <-full:
+	default:
+		t.Fatal("Buffer at 80% should have space")
+	}
+}
+
+func TestBufferUsageWatermarks(t *testing.T) {
+	buffer := newBufferUsage(100)
+	var levels []string
+	buffer.setWatermarkCallback(0.9, 0.5, func(level string) {
+		levels = append(levels, level)
+	})
+
+	buffer.reserve(80)
+	assert(t, len(levels), 0)
+	buffer.reserve(10)
+	buffer.reserve(10)
+	assert(t, levels, []string{"high"})
+
+	buffer.release(40)
+	assert(t, levels, []string{"high"})
+	buffer.release(10)
+	buffer.release(10)
+	assert(t, levels, []string{"high", "low"})
+
+	buffer.reserve(50)
+	assert(t, levels, []string{"high", "low", "high"})
+	assert(t, buffer.utilization(), 0.9)
+
+	unlimited := newBufferUsage(0)
+	unlimited.setWatermarkCallback(0, 0, func(level string) {
+		t.Fatalf("Unlimited buffer should not fire %s", level)
+	})
+	unlimited.reserve(1000)
+	unlimited.release(1000)
+}
+
+func TestRecordAccumulatorDrainOnePartition(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	defer producer.Close(time.Second)
+
+	siesta := TopicPartition{Topic: "siesta", Partition: 0}
+	_, err := producer.accumulator.drainOnePartition(siesta)
+	assert(t, err, ErrPartitionNotFound)
+
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "world"})
+	records, err := producer.accumulator.drainOnePartition(siesta)
+	checkErr(t, err)
+	assertFatal(t, len(records), 2)
+	assert(t, records[0].Value, "hello")
+	assert(t, records[1].Value, "world")
+
+	_, err = producer.accumulator.drainOnePartition(siesta)
+	assert(t, err, ErrPartitionNotFound)
+	assert(t, producer.ActiveTopics(), []string{})
+}
+
+func TestRecordAccumulatorPerPartitionStatsAfterClose(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 1)
+	producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	producer.Close(time.Second)
+
+	stats := make(chan map[TopicPartition]*PartitionAccumulatorStats, 1)
+	go func() {
+		stats <- producer.accumulator.PerPartitionStats()
+	}()
+	select {
+	case partitionStats := <-stats:
+		assert(t, len(partitionStats), 0)
+	case <-time.After(time.Second):
+		t.Error("PerPartitionStats blocked after Close")
+	}
+}
+
+func TestRecordAccumulatorTopicStats(t *testing.T) {
+	producer := testOfflineProducer(newTestBrokerLink(true), 0, 2)
+	defer producer.Close(time.Second)
+	producer.SetPartitioner("siesta", NewRoundRobinPartitioner())
+	assert(t, len(producer.accumulator.TopicStats()), 0)
+
+	for i := 0; i < 4; i++ {
+		producer.Send(&ProducerRecord{Topic: "siesta", Value: "hello"})
+	}
+	assertFatal(t, producer.FlushPartition("siesta", 0, time.Second), nil)
+
+	// records are appended to the batch of partition 1 asynchronously
+	var stats map[string]*TopicAccumulatorStats
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if stats = producer.accumulator.TopicStats(); stats["siesta"].PendingBytes == 10 {
+			break
+		}
+	}
+	assert(t, stats, map[string]*TopicAccumulatorStats{
+		"siesta": {PendingRecords: 2, PendingBytes: 10, BatchCount: 1, PartitionCount: 2},
+	})
+}
@@ -18,11 +18,12 @@ package siesta
 import (
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 type ConnectionRequest struct {
-	connection *net.TCPConn
+	connection net.Conn
 	request    *NetworkRequest
 }
 
@@ -35,6 +36,12 @@ type SelectorConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	RequiredAcks    int
+
+	// AckTimeout is the read timeout for responses to produce requests. ReadTimeout is used if it is not positive.
+	AckTimeout time.Duration
+
+	// RequestTimeouts overrides ReadTimeout and AckTimeout for requests with a given Kafka API key.
+	RequestTimeouts map[int16]time.Duration
 }
 
 func DefaultSelectorConfig() *SelectorConfig {
@@ -58,6 +65,7 @@ func NewSelectorConfig(producerConfig *ProducerConfig) *SelectorConfig {
 		ReadTimeout:     producerConfig.ReadTimeout,
 		WriteTimeout:    producerConfig.WriteTimeout,
 		RequiredAcks:    producerConfig.RequiredAcks,
+		AckTimeout:      producerConfig.AckTimeout,
 	}
 }
 
@@ -65,6 +73,22 @@ type Selector struct {
 	config    *SelectorConfig
 	requests  chan *NetworkRequest
 	responses chan *ConnectionRequest
+	metrics   *selectorMetrics
+}
+
+// selectorMetrics counts the bytes and IO errors of a Selector.
+type selectorMetrics struct {
+	bytesSent     int64
+	bytesReceived int64
+	writeErrors   int64
+	readErrors    int64
+}
+
+func (sm *selectorMetrics) reset() {
+	atomic.StoreInt64(&sm.bytesSent, 0)
+	atomic.StoreInt64(&sm.bytesReceived, 0)
+	atomic.StoreInt64(&sm.writeErrors, 0)
+	atomic.StoreInt64(&sm.readErrors, 0)
 }
 
 func NewSelector(config *SelectorConfig) *Selector {
@@ -72,6 +96,7 @@ func NewSelector(config *SelectorConfig) *Selector {
 		config:    config,
 		requests:  make(chan *NetworkRequest, config.MaxRequests),
 		responses: make(chan *ConnectionRequest, config.MaxRequests),
+		metrics:   new(selectorMetrics),
 	}
 	selector.Start()
 	return selector
@@ -113,12 +138,11 @@ func (s *Selector) requestDispatcher() {
 			continue
 		}
 
-		if err := s.send(id, conn, request.request); err != nil {
+		if err := s.send(id, conn, request); err != nil {
 			link.Failed()
+			link.DiscardConnection(conn)
 			if s.config.RequiredAcks > 0 {
 				request.responseChan <- &rawResponseAndError{nil, link, err}
-			} else {
-				link.ReturnConnection(conn)
 			}
 			continue
 		}
@@ -138,11 +162,11 @@ func (s *Selector) responseDispatcher() {
 		conn := connectionResponse.connection
 		responseChan := connectionResponse.request.responseChan
 
-		bytes, err := s.receive(conn)
+		bytes, err := s.receive(conn, connectionResponse.request)
 		if err != nil {
 			link.Failed()
+			link.DiscardConnection(conn)
 			responseChan <- &rawResponseAndError{nil, link, err}
-			link.ReturnConnection(conn)
 			continue
 		}
 
@@ -152,19 +176,48 @@ func (s *Selector) responseDispatcher() {
 	}
 }
 
-func (s *Selector) send(correlationID int32, conn *net.TCPConn, request Request) error {
-	writer := NewRequestHeader(correlationID, s.config.ClientID, request)
+// send writes a given request to a given connection, after running the request interceptors of its link on it.
+func (s *Selector) send(correlationID int32, conn net.Conn, request *NetworkRequest) error {
+	writer := NewRequestHeader(correlationID, s.config.ClientID, request.request)
 	bytes := make([]byte, writer.Size())
 	encoder := NewBinaryEncoder(bytes)
 	writer.Write(encoder)
+	bytes = linkInterceptors(request.link).request(request.request.Key(), bytes)
 
 	conn.SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
-	_, err := conn.Write(bytes)
+	n, err := conn.Write(bytes)
+	atomic.AddInt64(&s.metrics.bytesSent, int64(n))
+	if err != nil {
+		atomic.AddInt64(&s.metrics.writeErrors, 1)
+	}
 	return err
 }
 
-func (s *Selector) receive(conn *net.TCPConn) ([]byte, error) {
-	conn.SetReadDeadline(time.Now().Add(s.config.ReadTimeout))
+// readTimeout returns the time to wait for the response to a given request.
+func (s *Selector) readTimeout(request Request) time.Duration {
+	if timeout := s.config.RequestTimeouts[request.Key()]; timeout > 0 {
+		return timeout
+	}
+	if _, produce := request.(*ProduceRequest); produce && s.config.AckTimeout > 0 {
+		return s.config.AckTimeout
+	}
+	return s.config.ReadTimeout
+}
+
+// receive reads the response to a given request from a given connection and runs the response interceptors of its link on it.
+func (s *Selector) receive(conn net.Conn, request *NetworkRequest) ([]byte, error) {
+	response, err := s.read(conn, s.readTimeout(request.request))
+	if err != nil {
+		atomic.AddInt64(&s.metrics.readErrors, 1)
+		return nil, err
+	}
+
+	atomic.AddInt64(&s.metrics.bytesReceived, int64(len(response)+8))
+	return linkInterceptors(request.link).response(request.request.Key(), response), nil
+}
+
+func (s *Selector) read(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
 	header := make([]byte, 8)
 	_, err := io.ReadFull(conn, header)
 	if err != nil {
@@ -27,7 +27,8 @@ func TestSelector(t *testing.T) {
 	link := newBrokerLink(&Broker{ID: 1, Host: "localhost", Port: 9092},
 		true,
 		1*time.Minute,
-		5)
+		5,
+		nil)
 
 	request1 := new(ProduceRequest)
 	request1.RequiredAcks = 1
@@ -49,3 +50,17 @@ func TestSelector(t *testing.T) {
 	assert(t, response1.err, nil)
 	assert(t, response2.err, nil)
 }
+
+func TestSelectorReadTimeout(t *testing.T) {
+	config := DefaultSelectorConfig()
+	selector := &Selector{config: config}
+	assert(t, selector.readTimeout(new(ProduceRequest)), config.ReadTimeout)
+
+	config.AckTimeout = 30 * time.Second
+	assert(t, selector.readTimeout(new(ProduceRequest)), 30*time.Second)
+	assert(t, selector.readTimeout(NewMetadataRequest(nil)), config.ReadTimeout)
+
+	config.RequestTimeouts = map[int16]time.Duration{0: time.Minute, 3: 0}
+	assert(t, selector.readTimeout(new(ProduceRequest)), time.Minute)
+	assert(t, selector.readTimeout(NewMetadataRequest(nil)), config.ReadTimeout)
+}
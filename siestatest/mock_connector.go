@@ -0,0 +1,504 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package siestatest provides an in-memory siesta.Connector for testing code that produces to Kafka without a broker.
+package siestatest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/elodina/siesta"
+)
+
+// ErrNotSupported happens when calling a MockConnector method that needs a real broker, e.g. Fetch.
+var ErrNotSupported = errors.New("Not supported by MockConnector")
+
+// ErrClosed happens when getting a connection from a MockConnector that was closed.
+var ErrClosed = errors.New("MockConnector is closed")
+
+const produceKey int16 = 0
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type groupTopicPartition struct {
+	group string
+	topicPartition
+}
+
+// MockConnector implements siesta.Connector and accepts produce requests in memory. Every topic has the same number
+// of partitions, all led by a single fake broker. Records that were produced successfully are kept in the order
+// they arrived and can be inspected with RecordedMessages.
+type MockConnector struct {
+	lock        sync.Mutex
+	partitions  int32
+	topicErrors map[string]error
+	latency     time.Duration
+	records     []*siesta.ProducerRecord
+	offsets     map[topicPartition]int64
+	committed   map[groupTopicPartition]int64
+	link        *mockBrokerLink
+	closed      chan bool
+	closeOnce   sync.Once
+}
+
+// NewMockConnector creates a new MockConnector that reports a given number of partitions for every topic.
+func NewMockConnector(partitions int32) *MockConnector {
+	mc := &MockConnector{
+		partitions:  partitions,
+		topicErrors: make(map[string]error),
+		offsets:     make(map[topicPartition]int64),
+		committed:   make(map[groupTopicPartition]int64),
+		closed:      make(chan bool),
+	}
+	mc.link = &mockBrokerLink{connector: mc}
+	return mc
+}
+
+// RecordedMessages returns the records produced so far with their serialized keys and values as []byte.
+// Records produced to a topic with an injected error are not recorded.
+func (mc *MockConnector) RecordedMessages() []*siesta.ProducerRecord {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	records := make([]*siesta.ProducerRecord, len(mc.records))
+	copy(records, mc.records)
+	return records
+}
+
+// SetTopicError makes every following produce request to a given topic fail with a given error, which should be
+// one of siesta.BrokerErrors to reach the producer as is. A nil error lets requests succeed again.
+func (mc *MockConnector) SetTopicError(topic string, err error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if err == nil {
+		delete(mc.topicErrors, topic)
+	} else {
+		mc.topicErrors[topic] = err
+	}
+}
+
+// SetLatency makes the fake broker wait a given time before handling each produce request.
+func (mc *MockConnector) SetLatency(latency time.Duration) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.latency = latency
+}
+
+// SetPartitions changes the number of partitions reported for every topic.
+func (mc *MockConnector) SetPartitions(partitions int32) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.partitions = partitions
+}
+
+// GetTopicMetadata returns metadata for given topics, each with the configured number of partitions led by broker 0.
+func (mc *MockConnector) GetTopicMetadata(topics []string) (*siesta.MetadataResponse, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	response := &siesta.MetadataResponse{Brokers: []*siesta.Broker{{ID: 0, Host: "mock", Port: 9092}}}
+	for _, topic := range topics {
+		topicMetadata := &siesta.TopicMetadata{Error: siesta.ErrNoError, Topic: topic}
+		for i := int32(0); i < mc.partitions; i++ {
+			topicMetadata.PartitionsMetadata = append(topicMetadata.PartitionsMetadata, &siesta.PartitionMetadata{
+				Error:       siesta.ErrNoError,
+				PartitionID: i,
+				Leader:      0,
+				Replicas:    []int32{0},
+				ISR:         []int32{0},
+			})
+		}
+		response.TopicsMetadata = append(response.TopicsMetadata, topicMetadata)
+	}
+	return response, nil
+}
+
+// GetAvailableOffset returns 0 for siesta.EarliestTime and the number of records produced to a given partition otherwise.
+func (mc *MockConnector) GetAvailableOffset(topic string, partition int32, offsetTime int64) (int64, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if partition < 0 || partition >= mc.partitions {
+		return -1, siesta.ErrUnknownTopicOrPartition
+	}
+	if offsetTime == siesta.EarliestTime {
+		return 0, nil
+	}
+	return mc.offsets[topicPartition{topic, partition}], nil
+}
+
+// ListOffsets answers offset requests like GetAvailableOffset, with a single offset per partition.
+func (mc *MockConnector) ListOffsets(requestInfo map[string][]*siesta.PartitionOffsetRequestInfo) (*siesta.OffsetResponse, error) {
+	response := &siesta.OffsetResponse{PartitionErrorAndOffsets: make(map[string]map[int32]*siesta.PartitionOffsetsResponse)}
+	for topic, infos := range requestInfo {
+		response.PartitionErrorAndOffsets[topic] = make(map[int32]*siesta.PartitionOffsetsResponse)
+		for _, info := range infos {
+			offsets := &siesta.PartitionOffsetsResponse{Error: siesta.ErrNoError}
+			offset, err := mc.GetAvailableOffset(topic, info.Partition, info.Time)
+			if err != nil {
+				offsets.Error = err
+			} else {
+				offsets.Offsets = []int64{offset}
+			}
+			response.PartitionErrorAndOffsets[topic][info.Partition] = offsets
+		}
+	}
+	return response, nil
+}
+
+// Fetch is not supported and always returns ErrNotSupported.
+func (mc *MockConnector) Fetch(topic string, partition int32, offset int64) (*siesta.FetchResponse, error) {
+	return nil, ErrNotSupported
+}
+
+// GetOffset returns the offset last committed with CommitOffset for a given group and partition.
+func (mc *MockConnector) GetOffset(group string, topic string, partition int32) (int64, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	offset, exists := mc.committed[groupTopicPartition{group, topicPartition{topic, partition}}]
+	if !exists {
+		return -1, siesta.ErrUnknownTopicOrPartition
+	}
+	return offset, nil
+}
+
+// OffsetFetch returns the offsets last committed with CommitOffset for a given group and given partitions.
+func (mc *MockConnector) OffsetFetch(group string, topics map[string][]int32) (*siesta.OffsetFetchResponse, error) {
+	response := &siesta.OffsetFetchResponse{Offsets: make(map[string]map[int32]*siesta.OffsetMetadataAndError)}
+	for topic, partitions := range topics {
+		response.Offsets[topic] = make(map[int32]*siesta.OffsetMetadataAndError)
+		for _, partition := range partitions {
+			offset := &siesta.OffsetMetadataAndError{Error: siesta.ErrNoError}
+			offset.Offset, offset.Error = mc.GetOffset(group, topic, partition)
+			if offset.Error == nil {
+				offset.Error = siesta.ErrNoError
+			}
+			response.Offsets[topic][partition] = offset
+		}
+	}
+	return response, nil
+}
+
+// CommitOffset keeps a given offset for a given group and partition in memory.
+func (mc *MockConnector) CommitOffset(group string, topic string, partition int32, offset int64) error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.committed[groupTopicPartition{group, topicPartition{topic, partition}}] = offset
+	return nil
+}
+
+// GetLeader returns the fake broker for every existing partition.
+func (mc *MockConnector) GetLeader(topic string, partition int32) (siesta.BrokerLink, error) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if partition < 0 || partition >= mc.partitions {
+		return nil, siesta.ErrUnknownTopicOrPartition
+	}
+	return mc.link, nil
+}
+
+// Ping succeeds until this MockConnector is closed.
+func (mc *MockConnector) Ping(timeout time.Duration) error {
+	select {
+	case <-mc.closed:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Close closes all connections to the fake broker. The returned channel is closed once they are closed.
+func (mc *MockConnector) Close() <-chan bool {
+	mc.closeOnce.Do(func() {
+		close(mc.closed)
+		mc.link.closeAll()
+	})
+	return mc.closed
+}
+
+// WithRequestInterceptor returns this MockConnector without adding the function, as it sends no requests.
+func (mc *MockConnector) WithRequestInterceptor(fn func(apiKey int16, body []byte) []byte) siesta.Connector {
+	return mc
+}
+
+// WithResponseInterceptor returns this MockConnector without adding the function, as it receives no responses.
+func (mc *MockConnector) WithResponseInterceptor(fn func(apiKey int16, body []byte) []byte) siesta.Connector {
+	return mc
+}
+
+// produce records the messages of a decoded produce request and returns the error and base offset for each partition.
+func (mc *MockConnector) produce(messages map[topicPartition][]*siesta.Message) map[topicPartition]*siesta.ProduceResponseStatus {
+	mc.lock.Lock()
+	latency := mc.latency
+	mc.lock.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	statuses := make(map[topicPartition]*siesta.ProduceResponseStatus)
+	for tp, partitionMessages := range messages {
+		if err, exists := mc.topicErrors[tp.topic]; exists {
+			statuses[tp] = &siesta.ProduceResponseStatus{Error: err, Offset: -1}
+			continue
+		}
+		if tp.partition < 0 || tp.partition >= mc.partitions {
+			statuses[tp] = &siesta.ProduceResponseStatus{Error: siesta.ErrUnknownTopicOrPartition, Offset: -1}
+			continue
+		}
+
+		statuses[tp] = &siesta.ProduceResponseStatus{Error: siesta.ErrNoError, Offset: mc.offsets[tp]}
+		for _, message := range partitionMessages {
+			mc.records = append(mc.records, &siesta.ProducerRecord{Topic: tp.topic, Key: message.Key, Value: message.Value})
+		}
+		mc.offsets[tp] += int64(len(partitionMessages))
+	}
+	return statuses
+}
+
+// mockBrokerLink hands out in-memory connections to the fake broker of a MockConnector.
+type mockBrokerLink struct {
+	connector     *MockConnector
+	lock          sync.Mutex
+	correlationID int32
+	idle          []net.Conn
+	open          map[net.Conn]bool
+	attempts      int64
+}
+
+func (ml *mockBrokerLink) Failed()    {}
+func (ml *mockBrokerLink) Succeeded() {}
+
+// GetConnection returns an idle connection or opens a new one, which is served by its own goroutine.
+func (ml *mockBrokerLink) GetConnection() (int32, net.Conn, error) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	select {
+	case <-ml.connector.closed:
+		return -1, nil, ErrClosed
+	default:
+	}
+
+	ml.correlationID++
+	if len(ml.idle) > 0 {
+		conn := ml.idle[len(ml.idle)-1]
+		ml.idle = ml.idle[:len(ml.idle)-1]
+		return ml.correlationID, conn, nil
+	}
+
+	client, server := net.Pipe()
+	if ml.open == nil {
+		ml.open = make(map[net.Conn]bool)
+	}
+	ml.open[client] = true
+	ml.attempts++
+	go ml.connector.serve(server)
+	return ml.correlationID, client, nil
+}
+
+func (ml *mockBrokerLink) ReturnConnection(conn net.Conn) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	if ml.open[conn] {
+		ml.idle = append(ml.idle, conn)
+	}
+}
+
+func (ml *mockBrokerLink) DiscardConnection(conn net.Conn) {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.discard(conn)
+}
+
+func (ml *mockBrokerLink) discard(conn net.Conn) {
+	delete(ml.open, conn)
+	for i, idle := range ml.idle {
+		if idle == conn {
+			ml.idle = append(ml.idle[:i], ml.idle[i+1:]...)
+			break
+		}
+	}
+	conn.Close()
+}
+
+func (ml *mockBrokerLink) closeAll() {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	for conn := range ml.open {
+		ml.discard(conn)
+	}
+}
+
+func (ml *mockBrokerLink) ConnectionStats() siesta.ConnectionStats {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	return siesta.ConnectionStats{Attempts: ml.attempts, Active: int32(len(ml.open))}
+}
+
+func (ml *mockBrokerLink) ResetConnectionStats() {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+	ml.attempts = 0
+}
+
+// serve answers produce requests read from a given connection until it is closed or a request can not be handled.
+func (mc *MockConnector) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		length, _ := siesta.NewBinaryDecoder(size).GetInt32()
+		request := make([]byte, length)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+
+		correlationID, requiredAcks, messages, err := readProduceRequest(request)
+		if err != nil {
+			siesta.Errorf(mc, "Could not handle request: %s", err)
+			return
+		}
+		statuses := mc.produce(messages)
+		if requiredAcks == 0 {
+			continue
+		}
+		if _, err := conn.Write(produceResponse(correlationID, statuses)); err != nil {
+			return
+		}
+	}
+}
+
+// readProduceRequest decodes a produce request following the length field and flattens compressed message sets.
+func readProduceRequest(request []byte) (int32, int16, map[topicPartition][]*siesta.Message, error) {
+	decoder := siesta.NewBinaryDecoder(request)
+	apiKey, err := decoder.GetInt16()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if apiKey != produceKey {
+		return 0, 0, nil, ErrNotSupported
+	}
+	if _, err := decoder.GetInt16(); err != nil {
+		return 0, 0, nil, err
+	}
+	correlationID, err := decoder.GetInt32()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := decoder.GetString(); err != nil {
+		return 0, 0, nil, err
+	}
+	requiredAcks, err := decoder.GetInt16()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if _, err := decoder.GetInt32(); err != nil {
+		return 0, 0, nil, err
+	}
+
+	messages := make(map[topicPartition][]*siesta.Message)
+	topics, err := decoder.GetInt32()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for i := int32(0); i < topics; i++ {
+		topic, err := decoder.GetString()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		partitions, err := decoder.GetInt32()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		for j := int32(0); j < partitions; j++ {
+			partition, err := decoder.GetInt32()
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			messageSet, err := decoder.GetBytes()
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			messageAndOffsets, decodingErr := siesta.ReadMessageSet(siesta.NewBinaryDecoder(messageSet))
+			if decodingErr != nil {
+				return 0, 0, nil, decodingErr.Error()
+			}
+			tp := topicPartition{topic, partition}
+			messages[tp] = append(messages[tp], flatten(messageAndOffsets)...)
+		}
+	}
+	return correlationID, requiredAcks, messages, nil
+}
+
+func flatten(messageAndOffsets []*siesta.MessageAndOffset) []*siesta.Message {
+	var messages []*siesta.Message
+	for _, messageAndOffset := range messageAndOffsets {
+		if messageAndOffset.Message.Nested != nil {
+			messages = append(messages, flatten(messageAndOffset.Message.Nested)...)
+		} else {
+			messages = append(messages, messageAndOffset.Message)
+		}
+	}
+	return messages
+}
+
+// produceResponse encodes a produce response with given statuses, including size and correlation id.
+func produceResponse(correlationID int32, statuses map[topicPartition]*siesta.ProduceResponseStatus) []byte {
+	topics := make(map[string][]int32)
+	for tp := range statuses {
+		topics[tp.topic] = append(topics[tp.topic], tp.partition)
+	}
+
+	size := 4 + 4 + 4
+	for topic, partitions := range topics {
+		size += 2 + len(topic) + 4 + len(partitions)*(4+2+8)
+	}
+	bytes := make([]byte, size)
+	encoder := siesta.NewBinaryEncoder(bytes)
+	encoder.WriteInt32(int32(size - 4))
+	encoder.WriteInt32(correlationID)
+	encoder.WriteInt32(int32(len(topics)))
+	for topic, partitions := range topics {
+		encoder.WriteString(topic)
+		encoder.WriteInt32(int32(len(partitions)))
+		for _, partition := range partitions {
+			status := statuses[topicPartition{topic, partition}]
+			encoder.WriteInt32(partition)
+			encoder.WriteInt16(errorCode(status.Error))
+			encoder.WriteInt64(status.Offset)
+		}
+	}
+	return bytes
+}
+
+// errorCode returns the Kafka error code for a given error, or -1 for errors that are not siesta.BrokerErrors.
+func errorCode(err error) int16 {
+	for code, brokerErr := range siesta.BrokerErrors {
+		if brokerErr == err {
+			return code
+		}
+	}
+	return -1
+}
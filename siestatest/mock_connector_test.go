@@ -0,0 +1,176 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siestatest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/elodina/siesta"
+)
+
+func testProducer(t *testing.T, connector *MockConnector) *siesta.KafkaProducer {
+	config := siesta.NewProducerConfig()
+	config.Linger = 10 * time.Millisecond
+	producer, err := siesta.NewKafkaProducer(config, siesta.ByteSerializer, siesta.StringSerializer, connector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return producer
+}
+
+func TestMockConnectorRecordedMessages(t *testing.T) {
+	connector := NewMockConnector(2)
+	producer := testProducer(t, connector)
+	defer producer.Close(time.Second)
+
+	values := []string{"a", "b", "c"}
+	metadatas := make([]<-chan *siesta.RecordMetadata, len(values))
+	for i, value := range values {
+		metadatas[i] = producer.Send(&siesta.ProducerRecord{Topic: "siesta", Value: value})
+	}
+
+	offsets := make(map[int32]int64)
+	for _, metadataChan := range metadatas {
+		metadata := <-metadataChan
+		if metadata.Error != siesta.ErrNoError {
+			t.Fatalf("Expected no error, got %s", metadata.Error)
+		}
+		if metadata.Offset != offsets[metadata.Partition] {
+			t.Errorf("Expected offset %d for partition %d, got %d", offsets[metadata.Partition], metadata.Partition, metadata.Offset)
+		}
+		offsets[metadata.Partition]++
+	}
+
+	recorded := make(map[string]bool)
+	for _, record := range connector.RecordedMessages() {
+		if record.Topic != "siesta" {
+			t.Errorf("Expected topic siesta, got %s", record.Topic)
+		}
+		recorded[string(record.Value.([]byte))] = true
+	}
+	if len(recorded) != len(values) {
+		t.Fatalf("Expected %d recorded messages, got %v", len(values), recorded)
+	}
+	for _, value := range values {
+		if !recorded[value] {
+			t.Errorf("Expected %s to be recorded", value)
+		}
+	}
+
+	for partition, offset := range offsets {
+		available, err := connector.GetAvailableOffset("siesta", partition, siesta.LatestTime)
+		if err != nil || available != offset {
+			t.Errorf("Expected latest offset %d for partition %d, got %d, %v", offset, partition, available, err)
+		}
+	}
+
+	response, err := connector.ListOffsets(map[string][]*siesta.PartitionOffsetRequestInfo{
+		"siesta": {{Partition: 0, Time: siesta.LatestTime, MaxNumOffsets: 1}, {Partition: 2, Time: siesta.LatestTime, MaxNumOffsets: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := response.PartitionErrorAndOffsets["siesta"][0]; latest.Error != siesta.ErrNoError || latest.Offsets[0] != offsets[0] {
+		t.Errorf("Expected latest offset %d for partition 0, got %+v", offsets[0], latest)
+	}
+	if missing := response.PartitionErrorAndOffsets["siesta"][2]; missing.Error != siesta.ErrUnknownTopicOrPartition {
+		t.Errorf("Expected %s for partition 2, got %+v", siesta.ErrUnknownTopicOrPartition, missing)
+	}
+}
+
+func TestMockConnectorOffsetStorage(t *testing.T) {
+	connector := NewMockConnector(1)
+	if err := connector.CommitOffset("group", "siesta", 0, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := connector.OffsetFetch("group", map[string][]int32{"siesta": {0}, "other": {0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed := response.Offsets["siesta"][0]; committed.Error != siesta.ErrNoError || committed.Offset != 42 {
+		t.Errorf("Expected committed offset 42, got %+v", committed)
+	}
+	if missing := response.Offsets["other"][0]; missing.Error != siesta.ErrUnknownTopicOrPartition {
+		t.Errorf("Expected %s, got %+v", siesta.ErrUnknownTopicOrPartition, missing)
+	}
+}
+
+func TestMockConnectorTopicError(t *testing.T) {
+	connector := NewMockConnector(1)
+	connector.SetTopicError("failing", siesta.ErrMessageSizeTooLarge)
+	producer := testProducer(t, connector)
+	defer producer.Close(time.Second)
+
+	metadata := <-producer.Send(&siesta.ProducerRecord{Topic: "failing", Value: "hello"})
+	if metadata.Error != siesta.ErrMessageSizeTooLarge {
+		t.Errorf("Expected %s, got %s", siesta.ErrMessageSizeTooLarge, metadata.Error)
+	}
+	metadata = <-producer.Send(&siesta.ProducerRecord{Topic: "siesta", Value: "hello"})
+	if metadata.Error != siesta.ErrNoError {
+		t.Errorf("Expected no error, got %s", metadata.Error)
+	}
+	if recorded := len(connector.RecordedMessages()); recorded != 1 {
+		t.Errorf("Expected 1 recorded message, got %d", recorded)
+	}
+
+	connector.SetTopicError("failing", nil)
+	metadata = <-producer.Send(&siesta.ProducerRecord{Topic: "failing", Value: "hello"})
+	if metadata.Error != siesta.ErrNoError {
+		t.Errorf("Expected no error, got %s", metadata.Error)
+	}
+}
+
+func TestMockConnectorLatency(t *testing.T) {
+	connector := NewMockConnector(1)
+	connector.SetLatency(100 * time.Millisecond)
+	producer := testProducer(t, connector)
+	defer producer.Close(time.Second)
+
+	start := time.Now()
+	metadata := <-producer.Send(&siesta.ProducerRecord{Topic: "siesta", Value: "hello"})
+	if metadata.Error != siesta.ErrNoError {
+		t.Fatalf("Expected no error, got %s", metadata.Error)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the send to take at least 100ms, took %s", elapsed)
+	}
+}
+
+func TestMockConnectorTopicMetadata(t *testing.T) {
+	connector := NewMockConnector(3)
+	response, err := connector.GetTopicMetadata([]string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.TopicsMetadata) != 2 {
+		t.Fatalf("Expected metadata for 2 topics, got %d", len(response.TopicsMetadata))
+	}
+	for _, topicMetadata := range response.TopicsMetadata {
+		if len(topicMetadata.PartitionsMetadata) != 3 {
+			t.Errorf("Expected 3 partitions for %s, got %d", topicMetadata.Topic, len(topicMetadata.PartitionsMetadata))
+		}
+	}
+
+	if _, err := connector.GetLeader("a", 3); err != siesta.ErrUnknownTopicOrPartition {
+		t.Errorf("Expected %s, got %v", siesta.ErrUnknownTopicOrPartition, err)
+	}
+	<-connector.Close()
+	if err := connector.Ping(time.Second); err != ErrClosed {
+		t.Errorf("Expected %s, got %v", ErrClosed, err)
+	}
+}
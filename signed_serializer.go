@@ -0,0 +1,65 @@
+package siesta
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Deserializer turns the raw bytes of a message key or value back into a value.
+type Deserializer func([]byte) (interface{}, error)
+
+// Signer turns the serialized value of a record produced to a given topic into a signed payload.
+// Set ProducerConfig.ValueSigner to have the producer sign every value with the topic of its record.
+type Signer func(topic string, payload []byte) ([]byte, error)
+
+// NewHMACSHA256Signer creates a Signer that prepends a 32 byte HMAC-SHA256 signature of topic + "|" + payload.
+// keyFn is called for every record with its topic, which allows per-topic keys and key rotation.
+// A tombstone (nil payload) can't carry a signature without stopping being a tombstone, so such values are rejected with
+// ErrUnsignedTombstone unless allowTombstones is set. Only allow tombstones if consumers are fine with anyone being able
+// to delete keys on compacted topics.
+func NewHMACSHA256Signer(keyFn func(topic string) []byte, allowTombstones bool) Signer {
+	return func(topic string, payload []byte) ([]byte, error) {
+		if payload == nil {
+			if allowTombstones {
+				return nil, nil
+			}
+			return nil, ErrUnsignedTombstone
+		}
+
+		signature := hmacSHA256(keyFn(topic), topic, payload)
+		return append(signature, payload...), nil
+	}
+}
+
+// NewHMACSHA256Deserializer verifies the signature written by a Signer created with NewHMACSHA256Signer
+// and passes the remaining bytes to a given Deserializer. Returns ErrInvalidSignature if the signature does not match.
+// A Deserializer is not told which topic a message came from, so one has to be created per topic.
+// Tombstones are rejected with ErrInvalidSignature unless allowTombstones is set, see NewHMACSHA256Signer.
+func NewHMACSHA256Deserializer(inner Deserializer, topic string, keyFn func(topic string) []byte, allowTombstones bool) Deserializer {
+	return func(data []byte) (interface{}, error) {
+		if data == nil {
+			if allowTombstones {
+				return inner(nil)
+			}
+			return nil, ErrInvalidSignature
+		}
+
+		if len(data) < sha256.Size {
+			return nil, ErrInvalidSignature
+		}
+
+		signature, payload := data[:sha256.Size], data[sha256.Size:]
+		if !hmac.Equal(signature, hmacSHA256(keyFn(topic), topic, payload)) {
+			return nil, ErrInvalidSignature
+		}
+
+		return inner(payload)
+	}
+}
+
+func hmacSHA256(key []byte, topic string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(topic + "|"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
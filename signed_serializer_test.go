@@ -0,0 +1,92 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"testing"
+	"time"
+)
+
+func testSigningKey(topic string) []byte {
+	return []byte("key-for-" + topic)
+}
+
+func testByteDeserializer(data []byte) (interface{}, error) {
+	return data, nil
+}
+
+func TestHMACSHA256Signer(t *testing.T) {
+	signer := NewHMACSHA256Signer(testSigningKey, false)
+	deserializer := NewHMACSHA256Deserializer(testByteDeserializer, "siesta", testSigningKey, false)
+
+	signed, err := signer("siesta", []byte("hello world"))
+	assertFatal(t, err, nil)
+	assert(t, len(signed), 32+len("hello world"))
+
+	value, err := deserializer(signed)
+	assert(t, err, nil)
+	assert(t, value, []byte("hello world"))
+
+	tampered := append([]byte(nil), signed...)
+	tampered[len(tampered)-1] ^= 0xFF
+	_, err = deserializer(tampered)
+	assert(t, err, ErrInvalidSignature)
+
+	_, err = deserializer([]byte("short"))
+	assert(t, err, ErrInvalidSignature)
+
+	otherTopic := NewHMACSHA256Deserializer(testByteDeserializer, "other", testSigningKey, false)
+	_, err = otherTopic(signed)
+	assert(t, err, ErrInvalidSignature)
+}
+
+func TestHMACSHA256SignerTombstones(t *testing.T) {
+	_, err := NewHMACSHA256Signer(testSigningKey, false)("siesta", nil)
+	assert(t, err, ErrUnsignedTombstone)
+	_, err = NewHMACSHA256Deserializer(testByteDeserializer, "siesta", testSigningKey, false)(nil)
+	assert(t, err, ErrInvalidSignature)
+
+	tombstone, err := NewHMACSHA256Signer(testSigningKey, true)("siesta", nil)
+	assert(t, err, nil)
+	assert(t, tombstone, []byte(nil))
+	value, err := NewHMACSHA256Deserializer(testByteDeserializer, "siesta", testSigningKey, true)(nil)
+	assert(t, err, nil)
+	assert(t, value, []byte(nil))
+}
+
+func TestProducerValueSignerMultipleTopics(t *testing.T) {
+	connector := &testMetadataConnector{partitions: map[string]int32{"siesta1": 1, "siesta2": 1}, link: newTestBrokerLink(true)}
+	config := NewProducerConfig()
+	config.RequiredAcks = 0
+	config.Linger = time.Minute
+	config.ValueSigner = NewHMACSHA256Signer(testSigningKey, false)
+	producer, err := NewKafkaProducer(config, ByteSerializer, ByteSerializer, connector)
+	assertFatal(t, err, nil)
+	defer producer.Close(time.Second)
+
+	for _, topic := range []string{"siesta1", "siesta2"} {
+		record := &ProducerRecord{Topic: topic, Value: []byte("hello world")}
+		producer.Send(record)
+		assertFatal(t, producer.FlushPartition(topic, 0, time.Second), nil)
+
+		value, err := NewHMACSHA256Deserializer(testByteDeserializer, topic, testSigningKey, false)(record.encodedValue)
+		assert(t, err, nil)
+		assert(t, value, []byte("hello world"))
+	}
+
+	metadata := <-producer.Send(&ProducerRecord{Topic: "siesta1"})
+	assert(t, metadata.Error, ErrUnsignedTombstone)
+}
@@ -0,0 +1,82 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+)
+
+// TLSConfigFromFiles creates a tls.Config for ConnectorConfig.TLSConfig from PEM encoded files.
+// certFile and keyFile hold a client certificate and its key and are only needed if brokers require client authentication,
+// caFile holds the certificates to verify brokers with instead of the system ones. Empty file names are skipped.
+func TLSConfigFromFiles(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := new(tls.Config)
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// InsecureTLSConfig creates a tls.Config that accepts any broker certificate. Only use it for development.
+func InsecureTLSConfig() *tls.Config {
+	return &tls.Config{InsecureSkipVerify: true}
+}
+
+// tlsClientConfig returns a given config if it has a server name and a copy with the server name set to the host of
+// a given address otherwise. All exported fields are copied, e.g. VerifyPeerCertificate and GetClientCertificate on
+// newer Go versions. tls.Config.Clone needs Go 1.8, so the fields are copied with reflection.
+func tlsClientConfig(config *tls.Config, address string) *tls.Config {
+	if config.ServerName != "" {
+		return config
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return config
+	}
+
+	copied := new(tls.Config)
+	source := reflect.ValueOf(config).Elem()
+	target := reflect.ValueOf(copied).Elem()
+	for i := 0; i < target.NumField(); i++ {
+		if field := target.Field(i); field.CanSet() {
+			field.Set(source.Field(i))
+		}
+	}
+	copied.ServerName = host
+	return copied
+}
@@ -0,0 +1,167 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package siesta
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate for localhost and returns it PEM encoded with its key.
+func testCertificate(t *testing.T) (certPEM []byte, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErr(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	checkErr(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	checkErr(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// startTLSEchoListener starts a TLS listener that writes back everything it reads.
+func startTLSEchoListener(t *testing.T, certPEM []byte, keyPEM []byte) net.Listener {
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	checkErr(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}})
+	checkErr(t, err)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	return listener
+}
+
+func TestConnectionPoolTLS(t *testing.T) {
+	certPEM, keyPEM := testCertificate(t)
+	listener := startTLSEchoListener(t, certPEM, keyPEM)
+	defer listener.Close()
+
+	dir, err := ioutil.TempDir("", "siesta-tls")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	checkErr(t, ioutil.WriteFile(caFile, certPEM, 0600))
+
+	config, err := TLSConfigFromFiles("", "", caFile)
+	checkErr(t, err)
+	assertNot(t, config.RootCAs, nil)
+
+	for _, tlsConfig := range []*tls.Config{config, InsecureTLSConfig()} {
+		pool := newConnectionPool(listener.Addr().String(), 1, true, time.Second)
+		pool.tlsConfig = tlsConfig
+		conn, err := pool.Borrow()
+		checkErr(t, err)
+
+		conn.SetDeadline(time.Now().Add(time.Second))
+		_, err = conn.Write([]byte("ping"))
+		checkErr(t, err)
+		response := make([]byte, 4)
+		_, err = io.ReadFull(conn, response)
+		checkErr(t, err)
+		assert(t, string(response), "ping")
+		pool.Discard(conn)
+	}
+
+	// the certificate is not trusted without the CA
+	pool := newConnectionPool(listener.Addr().String(), 1, true, time.Second)
+	pool.tlsConfig = new(tls.Config)
+	_, err = pool.Borrow()
+	assertNot(t, err, nil)
+	assert(t, pool.Stats().Failures, int64(1))
+}
+
+func TestConnectionPoolTLSHandshakeTimeout(t *testing.T) {
+	listener := startTCPListener(t)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// never answer the handshake
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	pool := newConnectionPool(listener.Addr().String(), 1, true, time.Second)
+	pool.tlsConfig = InsecureTLSConfig()
+	pool.handshakeTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := pool.Borrow()
+	assertNot(t, err, nil)
+	assert(t, time.Since(start) < time.Second, true)
+}
+
+func TestTLSConfigFromFilesErrors(t *testing.T) {
+	_, err := TLSConfigFromFiles("missing.pem", "missing.key", "")
+	assertNot(t, err, nil)
+	_, err = TLSConfigFromFiles("", "", "missing.pem")
+	assertNot(t, err, nil)
+
+	file, err := ioutil.TempFile("", "siesta-tls")
+	checkErr(t, err)
+	defer os.Remove(file.Name())
+	file.Close()
+	_, err = TLSConfigFromFiles("", "", file.Name())
+	assertNot(t, err, nil)
+}
+
+func TestTLSClientConfigServerName(t *testing.T) {
+	assert(t, tlsClientConfig(new(tls.Config), "broker1:9093").ServerName, "broker1")
+
+	named := &tls.Config{ServerName: "kafka"}
+	assert(t, tlsClientConfig(named, "broker1:9093") == named, true)
+
+	config := &tls.Config{InsecureSkipVerify: true, ClientAuth: tls.RequireAnyClientCert, PreferServerCipherSuites: true}
+	copied := tlsClientConfig(config, "broker1:9093")
+	assert(t, copied.ServerName, "broker1")
+	assert(t, copied.InsecureSkipVerify, true)
+	assert(t, copied.ClientAuth, tls.RequireAnyClientCert)
+	assert(t, copied.PreferServerCipherSuites, true)
+	assert(t, config.ServerName, "")
+}
@@ -0,0 +1,414 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tools contains offline utilities that work with Kafka data without connecting to a broker.
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/elodina/siesta"
+	"github.com/golang/snappy"
+)
+
+// ErrCorruptEntry happens when an entry in a log segment is truncated or does not match its checksum.
+var ErrCorruptEntry = errors.New("Corrupt log segment entry")
+
+// ErrUnsupportedMagic happens when a log segment contains an entry in a message format newer than v2.
+var ErrUnsupportedMagic = errors.New("Unsupported message format version")
+
+// ErrUnsupportedCompression happens when a log segment entry is compressed with a codec other than gzip or snappy.
+var ErrUnsupportedCompression = errors.New("Unsupported compression codec")
+
+const (
+	// entryHeaderSize is the size of the offset and length fields preceding every message or record batch.
+	entryHeaderSize = 12
+
+	// magicPosition is the position of the magic byte after the entry header, both in messages (following the CRC)
+	// and in record batches (following the partition leader epoch).
+	magicPosition = 4
+
+	compressionMask       = 7
+	controlBatchAttribute = 1 << 5
+
+	// recordBatchHeaderSize is the size of a record batch header after the entry header.
+	recordBatchHeaderSize = 49
+
+	// recordBatchCRCStart is the position of the attributes after the entry header, the CRC of a record batch covers
+	// everything from there on.
+	recordBatchCRCStart = 9
+
+	snappyXerialHeaderSize = 16
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var snappyXerialMagic = []byte{130, 83, 78, 65, 80, 80, 89, 0}
+
+// RecordHeader is a single header of a record in the v2 message format.
+type RecordHeader struct {
+	Key   string
+	Value []byte
+}
+
+// ConsumerRecord is a single record read from a log segment.
+type ConsumerRecord struct {
+	Offset int64
+
+	// Timestamp is the time the record was created or appended, or zero in the v0 message format.
+	Timestamp time.Time
+
+	// Magic is the version of the message format the record was written in.
+	Magic int8
+
+	Key     []byte
+	Value   []byte
+	Headers []RecordHeader
+}
+
+// LogSegmentReader reads records from a Kafka log segment file (e.g. 00000000000000000000.log) one at a time.
+// Messages in the v0 and v1 formats and record batches in the v2 format may be mixed in a single segment,
+// as happens after a message format upgrade. Compressed messages and batches are decompressed and control batches are
+// skipped. Records of aborted transactions are returned as well, as they can only be told apart with the
+// transaction index.
+type LogSegmentReader struct {
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	pending []*ConsumerRecord
+}
+
+// NewLogSegmentReader opens a log segment file at a given path for reading.
+func NewLogSegmentReader(path string) (*LogSegmentReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogSegmentReader{
+		file:   file,
+		reader: bufio.NewReader(file),
+	}, nil
+}
+
+// ReadNext returns the next record in the segment, or io.EOF once all records were read.
+// Errors for corrupt entries are wrapped with the file position of the entry.
+func (lsr *LogSegmentReader) ReadNext() (*ConsumerRecord, error) {
+	for len(lsr.pending) == 0 {
+		records, err := lsr.readEntry()
+		if err != nil {
+			return nil, err
+		}
+		lsr.pending = records
+	}
+
+	record := lsr.pending[0]
+	lsr.pending = lsr.pending[1:]
+	return record, nil
+}
+
+// Close closes the underlying segment file.
+func (lsr *LogSegmentReader) Close() error {
+	return lsr.file.Close()
+}
+
+// readEntry reads the next message or record batch and decodes the records in it.
+func (lsr *LogSegmentReader) readEntry() ([]*ConsumerRecord, error) {
+	position := lsr.offset
+	header := make([]byte, entryHeaderSize)
+	n, err := io.ReadFull(lsr.reader, header)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, lsr.corrupt(position, fmt.Errorf("truncated entry header of %d bytes", n))
+	}
+
+	offset := int64(binary.BigEndian.Uint64(header))
+	size := int32(binary.BigEndian.Uint32(header[8:]))
+	if size == 0 {
+		// segments may be preallocated, the rest of the file is zeros then
+		return nil, io.EOF
+	}
+	if size <= magicPosition {
+		return nil, lsr.corrupt(position, fmt.Errorf("invalid entry size %d", size))
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(lsr.reader, body); err != nil {
+		return nil, lsr.corrupt(position, fmt.Errorf("truncated entry of %d bytes", size))
+	}
+	lsr.offset += int64(entryHeaderSize) + int64(size)
+
+	var records []*ConsumerRecord
+	switch magic := int8(body[magicPosition]); magic {
+	case 0, 1:
+		records, err = readMessage(offset, body)
+	case 2:
+		records, err = readRecordBatch(offset, body)
+	default:
+		return nil, fmt.Errorf("%s at position %d: %d", ErrUnsupportedMagic, position, magic)
+	}
+	if err != nil {
+		return nil, lsr.corrupt(position, err)
+	}
+	return records, nil
+}
+
+func (lsr *LogSegmentReader) corrupt(position int64, err error) error {
+	if err == ErrUnsupportedCompression {
+		return fmt.Errorf("%s at position %d", err, position)
+	}
+	return fmt.Errorf("%s at position %d: %s", ErrCorruptEntry, position, err)
+}
+
+// readMessage decodes a message in the v0 or v1 format following the entry header. Compressed messages wrap a message
+// set, whose records are returned instead.
+func readMessage(offset int64, body []byte) ([]*ConsumerRecord, error) {
+	decoder := siesta.NewBinaryDecoder(body)
+	crc, _ := decoder.GetInt32()
+	if uint32(crc) != crc32.ChecksumIEEE(body[4:]) {
+		return nil, errors.New("message does not match its CRC")
+	}
+	magic, _ := decoder.GetInt8()
+	attributes, err := decoder.GetInt8()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &ConsumerRecord{Offset: offset, Magic: magic}
+	if magic == 1 {
+		timestamp, err := decoder.GetInt64()
+		if err != nil {
+			return nil, err
+		}
+		record.Timestamp = millisToTime(timestamp)
+	}
+	if record.Key, err = decoder.GetBytes(); err != nil {
+		return nil, err
+	}
+	if record.Value, err = decoder.GetBytes(); err != nil {
+		return nil, err
+	}
+
+	codec := attributes & compressionMask
+	if codec == 0 {
+		return []*ConsumerRecord{record}, nil
+	}
+
+	messageSet, err := decompress(codec, record.Value)
+	if err != nil {
+		return nil, err
+	}
+	return readMessageSet(offset, magic, messageSet)
+}
+
+// readMessageSet decodes the messages wrapped by a compressed message with a given offset. Inner offsets are absolute
+// in the v0 format and relative to the first inner message in the v1 format, where the wrapper holds the last offset.
+func readMessageSet(wrapperOffset int64, magic int8, messageSet []byte) ([]*ConsumerRecord, error) {
+	var records []*ConsumerRecord
+	for len(messageSet) > 0 {
+		if len(messageSet) < entryHeaderSize {
+			return nil, errors.New("truncated nested message")
+		}
+		offset := int64(binary.BigEndian.Uint64(messageSet))
+		size := int(binary.BigEndian.Uint32(messageSet[8:]))
+		if size <= magicPosition || len(messageSet) < entryHeaderSize+size {
+			return nil, errors.New("truncated nested message")
+		}
+
+		nested, err := readMessage(offset, messageSet[entryHeaderSize:entryHeaderSize+size])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, nested...)
+		messageSet = messageSet[entryHeaderSize+size:]
+	}
+
+	if magic == 1 && len(records) > 0 {
+		base := wrapperOffset - records[len(records)-1].Offset
+		for _, record := range records {
+			record.Offset += base
+		}
+	}
+	return records, nil
+}
+
+// readRecordBatch decodes a record batch in the v2 format following its base offset and length.
+func readRecordBatch(baseOffset int64, body []byte) ([]*ConsumerRecord, error) {
+	if len(body) < recordBatchHeaderSize {
+		return nil, errors.New("truncated record batch header")
+	}
+	decoder := siesta.NewBinaryDecoder(body)
+	decoder.GetInt32() // partition leader epoch
+	decoder.GetInt8()  // magic
+	crc, _ := decoder.GetInt32()
+	if uint32(crc) != crc32.Checksum(body[recordBatchCRCStart:], castagnoli) {
+		return nil, errors.New("record batch does not match its CRC")
+	}
+	attributes, _ := decoder.GetInt16()
+	decoder.GetInt32() // last offset delta
+	firstTimestamp, _ := decoder.GetInt64()
+	decoder.GetInt64() // max timestamp
+	decoder.GetInt64() // producer id
+	decoder.GetInt16() // producer epoch
+	decoder.GetInt32() // base sequence
+	count, _ := decoder.GetInt32()
+
+	if attributes&controlBatchAttribute != 0 {
+		return nil, nil
+	}
+
+	data := body[recordBatchHeaderSize:]
+	if codec := int8(attributes & compressionMask); codec != 0 {
+		var err error
+		if data, err = decompress(codec, data); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := &varintReader{data: data}
+	records := make([]*ConsumerRecord, 0, count)
+	for i := int32(0); i < count; i++ {
+		reader.varint() // record length
+		reader.int8()   // attributes
+		timestampDelta := reader.varint()
+		offsetDelta := reader.varint()
+		record := &ConsumerRecord{
+			Offset:    baseOffset + offsetDelta,
+			Timestamp: millisToTime(firstTimestamp + timestampDelta),
+			Magic:     2,
+			Key:       reader.bytes(),
+			Value:     reader.bytes(),
+		}
+		headers := reader.varint()
+		for j := int64(0); j < headers && reader.err == nil; j++ {
+			key := reader.bytes()
+			record.Headers = append(record.Headers, RecordHeader{Key: string(key), Value: reader.bytes()})
+		}
+		if reader.err != nil {
+			return nil, reader.err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// varintReader decodes the zig-zag encoded varint fields of v2 records, keeping the first error.
+type varintReader struct {
+	data []byte
+	err  error
+}
+
+func (vr *varintReader) varint() int64 {
+	if vr.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(vr.data)
+	if n <= 0 {
+		vr.err = errors.New("invalid varint in record")
+		return 0
+	}
+	vr.data = vr.data[n:]
+	return value
+}
+
+func (vr *varintReader) int8() int8 {
+	if vr.err != nil {
+		return 0
+	}
+	if len(vr.data) == 0 {
+		vr.err = errors.New("truncated record")
+		return 0
+	}
+	value := int8(vr.data[0])
+	vr.data = vr.data[1:]
+	return value
+}
+
+// bytes reads a varint length followed by that many bytes. A negative length is a null value.
+func (vr *varintReader) bytes() []byte {
+	length := vr.varint()
+	if vr.err != nil || length < 0 {
+		return nil
+	}
+	if int64(len(vr.data)) < length {
+		vr.err = errors.New("truncated record")
+		return nil
+	}
+	value := vr.data[:length]
+	vr.data = vr.data[length:]
+	return value
+}
+
+func decompress(codec int8, data []byte) ([]byte, error) {
+	switch codec {
+	case 1:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(reader)
+	case 2:
+		return snappyDecode(data)
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
+
+// snappyDecode decodes raw snappy data as well as the xerial framing used by the Java client.
+func snappyDecode(data []byte) ([]byte, error) {
+	if len(data) < snappyXerialHeaderSize || !bytes.Equal(data[:len(snappyXerialMagic)], snappyXerialMagic) {
+		return snappy.Decode(nil, data)
+	}
+
+	var result []byte
+	for current := snappyXerialHeaderSize; current < len(data); {
+		if current+4 > len(data) {
+			return nil, errors.New("truncated snappy chunk")
+		}
+		size := int(binary.BigEndian.Uint32(data[current:]))
+		current += 4
+		if current+size > len(data) {
+			return nil, errors.New("truncated snappy chunk")
+		}
+		chunk, err := snappy.Decode(nil, data[current:current+size])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+		current += size
+	}
+	return result, nil
+}
+
+func millisToTime(millis int64) time.Time {
+	if millis < 0 {
+		return time.Time{}
+	}
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond))
+}
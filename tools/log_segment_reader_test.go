@@ -0,0 +1,268 @@
+/* Licensed to the Apache Software Foundation (ASF) under one or more
+contributor license agreements.  See the NOTICE file distributed with
+this work for additional information regarding copyright ownership.
+The ASF licenses this file to You under the Apache License, Version 2.0
+(the "License"); you may not use this file except in compliance with
+the License.  You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License. */
+
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeInt(buffer *bytes.Buffer, value interface{}) {
+	binary.Write(buffer, binary.BigEndian, value)
+}
+
+func writeBytes(buffer *bytes.Buffer, value []byte) {
+	if value == nil {
+		writeInt(buffer, int32(-1))
+		return
+	}
+	writeInt(buffer, int32(len(value)))
+	buffer.Write(value)
+}
+
+func writeVarint(buffer *bytes.Buffer, value int64) {
+	varint := make([]byte, binary.MaxVarintLen64)
+	buffer.Write(varint[:binary.PutVarint(varint, value)])
+}
+
+func writeVarintBytes(buffer *bytes.Buffer, value []byte) {
+	if value == nil {
+		writeVarint(buffer, -1)
+		return
+	}
+	writeVarint(buffer, int64(len(value)))
+	buffer.Write(value)
+}
+
+func gzipped(data []byte) []byte {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(data)
+	writer.Close()
+	return buffer.Bytes()
+}
+
+// testMessage encodes a v0 or v1 message with its offset and size.
+func testMessage(offset int64, magic int8, attributes int8, timestamp int64, key []byte, value []byte) []byte {
+	var message bytes.Buffer
+	writeInt(&message, magic)
+	writeInt(&message, attributes)
+	if magic == 1 {
+		writeInt(&message, timestamp)
+	}
+	writeBytes(&message, key)
+	writeBytes(&message, value)
+
+	var entry bytes.Buffer
+	writeInt(&entry, offset)
+	writeInt(&entry, int32(message.Len()+4))
+	writeInt(&entry, crc32.ChecksumIEEE(message.Bytes()))
+	entry.Write(message.Bytes())
+	return entry.Bytes()
+}
+
+type testRecord struct {
+	timestampDelta int64
+	offsetDelta    int64
+	key            []byte
+	value          []byte
+	headers        []RecordHeader
+}
+
+// testRecordBatch encodes a v2 record batch with its base offset and length.
+func testRecordBatch(baseOffset int64, attributes int16, firstTimestamp int64, records []testRecord) []byte {
+	var encoded bytes.Buffer
+	for _, record := range records {
+		var body bytes.Buffer
+		writeInt(&body, int8(0))
+		writeVarint(&body, record.timestampDelta)
+		writeVarint(&body, record.offsetDelta)
+		writeVarintBytes(&body, record.key)
+		writeVarintBytes(&body, record.value)
+		writeVarint(&body, int64(len(record.headers)))
+		for _, header := range record.headers {
+			writeVarintBytes(&body, []byte(header.Key))
+			writeVarintBytes(&body, header.Value)
+		}
+		writeVarint(&encoded, int64(body.Len()))
+		encoded.Write(body.Bytes())
+	}
+	data := encoded.Bytes()
+	if attributes&compressionMask == 1 {
+		data = gzipped(data)
+	}
+
+	var checked bytes.Buffer
+	writeInt(&checked, attributes)
+	writeInt(&checked, int32(len(records)-1))
+	writeInt(&checked, firstTimestamp)
+	writeInt(&checked, firstTimestamp)
+	writeInt(&checked, int64(-1))
+	writeInt(&checked, int16(-1))
+	writeInt(&checked, int32(-1))
+	writeInt(&checked, int32(len(records)))
+	checked.Write(data)
+
+	var batch bytes.Buffer
+	writeInt(&batch, baseOffset)
+	writeInt(&batch, int32(checked.Len()+9))
+	writeInt(&batch, int32(0))
+	writeInt(&batch, int8(2))
+	writeInt(&batch, crc32.Checksum(checked.Bytes(), castagnoli))
+	batch.Write(checked.Bytes())
+	return batch.Bytes()
+}
+
+func writeSegment(t *testing.T, entries ...[]byte) string {
+	dir, err := ioutil.TempDir("", "siesta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "00000000000000000000.log")
+	if err := ioutil.WriteFile(path, bytes.Join(entries, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readAll(t *testing.T, path string) ([]*ConsumerRecord, error) {
+	reader, err := NewLogSegmentReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var records []*ConsumerRecord
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestLogSegmentReaderFormats(t *testing.T) {
+	var nested bytes.Buffer
+	nested.Write(testMessage(0, 1, 0, 3000, nil, []byte("nested-0")))
+	nested.Write(testMessage(1, 1, 0, 3001, nil, []byte("nested-1")))
+
+	path := writeSegment(t,
+		testMessage(0, 0, 0, 0, []byte("k0"), []byte("v0")),
+		testMessage(1, 1, 0, 1000, nil, []byte("v1")),
+		testMessage(3, 1, 1, 3001, nil, gzipped(nested.Bytes())),
+		testRecordBatch(4, 0, 2000, []testRecord{
+			{0, 0, []byte("k4"), []byte("v4"), []RecordHeader{{Key: "trace", Value: []byte("abc")}}},
+			{5, 1, nil, nil, nil},
+		}),
+		testRecordBatch(6, 1, 4000, []testRecord{{0, 0, nil, []byte("compressed"), nil}}),
+		testRecordBatch(7, controlBatchAttribute, 5000, []testRecord{{0, 0, []byte{0, 0, 0, 0}, []byte{0, 0, 0, 0, 0, 0}, nil}}),
+		make([]byte, 64),
+	)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	records, err := readAll(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		offset    int64
+		magic     int8
+		timestamp int64
+		key       string
+		value     string
+	}{
+		{0, 0, -1, "k0", "v0"},
+		{1, 1, 1000, "", "v1"},
+		{2, 1, 3000, "", "nested-0"},
+		{3, 1, 3001, "", "nested-1"},
+		{4, 2, 2000, "k4", "v4"},
+		{5, 2, 2005, "", ""},
+		{6, 2, 4000, "", "compressed"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		want := expected[i]
+		if record.Offset != want.offset || record.Magic != want.magic || string(record.Key) != want.key || string(record.Value) != want.value {
+			t.Errorf("Record %d: expected %+v, got offset %d, magic %d, key %q, value %q", i, want, record.Offset, record.Magic, record.Key, record.Value)
+		}
+		if want.timestamp < 0 {
+			if !record.Timestamp.IsZero() {
+				t.Errorf("Record %d: expected no timestamp, got %s", i, record.Timestamp)
+			}
+		} else if !record.Timestamp.Equal(time.Unix(0, want.timestamp*int64(time.Millisecond))) {
+			t.Errorf("Record %d: expected timestamp %d, got %s", i, want.timestamp, record.Timestamp)
+		}
+	}
+
+	if len(records[4].Headers) != 1 || records[4].Headers[0].Key != "trace" || string(records[4].Headers[0].Value) != "abc" {
+		t.Errorf("Expected a trace header, got %+v", records[4].Headers)
+	}
+	if records[5].Key != nil || records[5].Value != nil {
+		t.Errorf("Expected null key and value, got %q, %q", records[5].Key, records[5].Value)
+	}
+}
+
+func TestLogSegmentReaderCorruptEntries(t *testing.T) {
+	corrupt := testMessage(1, 0, 0, 0, nil, []byte("corrupt"))
+	corrupt[len(corrupt)-1] ^= 0xff
+	batch := testRecordBatch(2, 0, 0, []testRecord{{0, 0, nil, []byte("corrupt"), nil}})
+	batch[len(batch)-1] ^= 0xff
+	valid := testMessage(0, 0, 0, 0, nil, []byte("valid"))
+
+	for name, entry := range map[string][]byte{
+		"message CRC":      corrupt,
+		"record batch CRC": batch,
+		"truncated entry":  valid[:len(valid)-1],
+		"truncated header": valid[:entryHeaderSize-1],
+	} {
+		path := writeSegment(t, valid, entry)
+		records, err := readAll(t, path)
+		os.RemoveAll(filepath.Dir(path))
+
+		if len(records) != 1 || string(records[0].Value) != "valid" {
+			t.Errorf("%s: expected the valid record before the corrupt entry, got %d records", name, len(records))
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), ErrCorruptEntry.Error()) {
+			t.Errorf("%s: expected %s, got %v", name, ErrCorruptEntry, err)
+		}
+	}
+}
+
+func TestLogSegmentReaderUnsupportedCompression(t *testing.T) {
+	path := writeSegment(t, testRecordBatch(0, 3, 0, []testRecord{{0, 0, nil, []byte("lz4"), nil}}))
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := readAll(t, path)
+	if err == nil || !strings.HasPrefix(err.Error(), ErrUnsupportedCompression.Error()) {
+		t.Errorf("Expected %s, got %v", ErrUnsupportedCompression, err)
+	}
+}